@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"sync"
 
 	"github.com/sph/youtube-url-replacer/backend/resolvers"
@@ -8,33 +9,36 @@ import (
 
 // InMemoryCache is a simple thread-safe map implementation of Cache
 type InMemoryCache struct {
-	mu    sync.RWMutex
-	store map[string]string
+	mu     sync.RWMutex
+	store  map[string]*resolvers.Result
+	hashes map[string]string // Key hash -> key, for hash-prefix lookups
 }
 
 func NewInMemoryCache() resolvers.Cache {
 	return &InMemoryCache{
-		store: make(map[string]string),
+		store:  make(map[string]*resolvers.Result),
+		hashes: make(map[string]string),
 	}
 }
 
-func (c *InMemoryCache) Get(videoID string) (string, bool) {
+func (c *InMemoryCache) Get(videoID string) (*resolvers.Result, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	val, ok := c.store[videoID]
 	return val, ok
 }
 
-func (c *InMemoryCache) Set(videoID string, title string) {
+func (c *InMemoryCache) Set(videoID string, res *resolvers.Result) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.store[videoID] = title
+	c.store[videoID] = res
+	c.hashes[resolvers.HashKey(videoID)] = videoID
 }
 
-func (c *InMemoryCache) GetMulti(videoIDs []string) map[string]string {
+func (c *InMemoryCache) GetMulti(videoIDs []string) map[string]*resolvers.Result {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	results := make(map[string]string)
+	results := make(map[string]*resolvers.Result)
 	for _, id := range videoIDs {
 		if val, ok := c.store[id]; ok {
 			results[id] = val
@@ -42,3 +46,18 @@ func (c *InMemoryCache) GetMulti(videoIDs []string) map[string]string {
 	}
 	return results
 }
+
+func (c *InMemoryCache) GetByHashPrefix(prefix string, limit int) map[string]*resolvers.Result {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	results := make(map[string]*resolvers.Result)
+	for hash, key := range c.hashes {
+		if len(results) >= limit {
+			break
+		}
+		if strings.HasPrefix(hash, prefix) {
+			results[hash] = c.store[key]
+		}
+	}
+	return results
+}
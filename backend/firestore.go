@@ -2,8 +2,7 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"time"
@@ -29,36 +28,50 @@ func NewFirestoreCache(projectID string) (resolvers.Cache, error) {
 	return &FirestoreCache{client: client}, nil
 }
 
-func hashKey(key string) string {
-	hash := sha256.Sum256([]byte(key))
-	return hex.EncodeToString(hash[:])
+// decodeResult reads a cached document. Entries written before results were
+// stored as JSON only carry a title, which is still usable.
+func decodeResult(data map[string]interface{}) (*resolvers.Result, bool) {
+	if raw, ok := data["result"].(string); ok {
+		var res resolvers.Result
+		if err := json.Unmarshal([]byte(raw), &res); err == nil {
+			return &res, true
+		}
+	}
+	if title, ok := data["title"].(string); ok {
+		return &resolvers.Result{Title: title}, true
+	}
+	return nil, false
 }
 
-func (f *FirestoreCache) Get(key string) (string, bool) {
+func (f *FirestoreCache) Get(key string) (*resolvers.Result, bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	doc, err := f.client.Collection(collectionName).Doc(hashKey(key)).Get(ctx)
+	doc, err := f.client.Collection(collectionName).Doc(resolvers.HashKey(key)).Get(ctx)
 	if status.Code(err) == codes.NotFound {
-		return "", false
+		return nil, false
 	}
 	if err != nil {
 		log.Printf("Error reading from Firestore: %v", err)
-		return "", false
+		return nil, false
 	}
 
-	if title, ok := doc.Data()["title"].(string); ok {
-		return title, true
-	}
-	return "", false
+	return decodeResult(doc.Data())
 }
 
-func (f *FirestoreCache) Set(key string, title string) {
+func (f *FirestoreCache) Set(key string, res *resolvers.Result) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	_, err := f.client.Collection(collectionName).Doc(hashKey(key)).Set(ctx, map[string]interface{}{
-		"title":     title,
+	encoded, err := json.Marshal(res)
+	if err != nil {
+		log.Printf("Error encoding result for Firestore: %v", err)
+		return
+	}
+
+	_, err = f.client.Collection(collectionName).Doc(resolvers.HashKey(key)).Set(ctx, map[string]interface{}{
+		"title":     res.Title,
+		"result":    string(encoded),
 		"updatedAt": firestore.ServerTimestamp,
 		"original":  key, // Store original key for debugging
 	})
@@ -67,7 +80,32 @@ func (f *FirestoreCache) Set(key string, title string) {
 	}
 }
 
-func (f *FirestoreCache) GetMulti(keys []string) map[string]string {
+// GetByHashPrefix queries the document IDs, which are the key hashes, as a range
+func (f *FirestoreCache) GetByHashPrefix(prefix string, limit int) map[string]*resolvers.Result {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	col := f.client.Collection(collectionName)
+	// "g" sorts after every hex digit, so [prefix, prefix+"g") is exactly the prefix range
+	docs, err := col.Where(firestore.DocumentID, ">=", col.Doc(prefix)).
+		Where(firestore.DocumentID, "<", col.Doc(prefix+"g")).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		log.Printf("Error querying Firestore by hash prefix: %v", err)
+		return map[string]*resolvers.Result{}
+	}
+
+	results := make(map[string]*resolvers.Result)
+	for _, doc := range docs {
+		if res, ok := decodeResult(doc.Data()); ok {
+			results[doc.Ref.ID] = res
+		}
+	}
+	return results
+}
+
+func (f *FirestoreCache) GetMulti(keys []string) map[string]*resolvers.Result {
 	// Firestore allows getting multiple documents by reference, but the SDK
 	// GetAll API takes DocumentRefs.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -75,22 +113,22 @@ func (f *FirestoreCache) GetMulti(keys []string) map[string]string {
 
 	var refs []*firestore.DocumentRef
 	for _, key := range keys {
-		refs = append(refs, f.client.Collection(collectionName).Doc(hashKey(key)))
+		refs = append(refs, f.client.Collection(collectionName).Doc(resolvers.HashKey(key)))
 	}
 
 	docs, err := f.client.GetAll(ctx, refs)
 	if err != nil {
 		log.Printf("Error executing GetAll on Firestore: %v", err)
-		return map[string]string{}
+		return map[string]*resolvers.Result{}
 	}
 
-	results := make(map[string]string)
+	results := make(map[string]*resolvers.Result)
 	for i, doc := range docs {
 		if !doc.Exists() {
 			continue
 		}
-		if title, ok := doc.Data()["title"].(string); ok {
-			results[keys[i]] = title
+		if res, ok := decodeResult(doc.Data()); ok {
+			results[keys[i]] = res
 		}
 	}
 	return results
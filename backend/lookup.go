@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/middleware"
+	"github.com/sph/youtube-url-replacer/backend/resolvers"
+)
+
+// Hash prefix bounds, in hex characters. Short prefixes keep every lookup
+// ambiguous among many cached URLs; long ones would identify the URL.
+const (
+	minLookupPrefix = 4
+	maxLookupPrefix = 8
+)
+
+type LookupRequest struct {
+	Prefixes []string `json:"prefixes"`
+}
+
+type LookupResponse struct {
+	// Matches maps each requested prefix to the cached results whose key hash starts with it, keyed by full hash
+	Matches map[string]map[string]*LookupResult `json:"matches"`
+	// Truncated lists prefixes that matched more than MaxMatches entries
+	Truncated []string `json:"truncated,omitempty"`
+}
+
+// LookupResult is the display part of a cached result. Anyone can walk every
+// prefix and read the whole cache, so the URLs a result carries (final,
+// canonical and clean URLs, the redirect chain, validators, certificate
+// names) are left out: the hash is all a client needs to pick its match.
+type LookupResult struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	Platform    string              `json:"platform"`
+	Image       *resolvers.Image    `json:"image,omitempty"`
+	SiteName    string              `json:"siteName,omitempty"`
+	Author      string              `json:"author,omitempty"`
+	PublishedAt *time.Time          `json:"publishedAt,omitempty"`
+	UpdatedAt   *time.Time          `json:"updatedAt,omitempty"`
+	ContentType string              `json:"contentType,omitempty"`
+	Language    string              `json:"language,omitempty"`
+	FileName    string              `json:"fileName,omitempty"`
+	Risk        string              `json:"risk,omitempty"`
+	Reputation  string              `json:"reputation,omitempty"`
+	Warnings    []resolvers.Warning `json:"warnings,omitempty"`
+}
+
+func newLookupResult(res *resolvers.Result) *LookupResult {
+	return &LookupResult{
+		Title:       res.Title,
+		Description: res.Description,
+		Platform:    res.Platform,
+		Image:       res.Image,
+		SiteName:    res.SiteName,
+		Author:      res.Author,
+		PublishedAt: res.PublishedAt,
+		UpdatedAt:   res.UpdatedAt,
+		ContentType: res.ContentType,
+		Language:    res.Language,
+		FileName:    res.FileName,
+		Risk:        res.Risk,
+		Reputation:  res.Reputation,
+		Warnings:    res.Warnings,
+	}
+}
+
+// LookupHandler serves k-anonymous lookups: clients send short SHA-256
+// prefixes of canonical URLs and match the full hashes locally, so the
+// server never learns which link was on the page. It only reads the cache
+// and never resolves anything.
+type LookupHandler struct {
+	cache        resolvers.HashPrefixCache
+	MaxBodyBytes int64
+	MaxItems     int
+	MaxMatches   int
+	// Limiter, when set, charges each client one token per distinct prefix,
+	// which bounds how fast the cache can be enumerated
+	Limiter *middleware.RateLimiter
+}
+
+func NewLookupHandler(cache resolvers.HashPrefixCache) *LookupHandler {
+	return &LookupHandler{
+		cache:        cache,
+		MaxBodyBytes: 10 * 1024,
+		MaxItems:     50,
+		MaxMatches:   100,
+	}
+}
+
+// validPrefix accepts lower-case hex within the length bounds
+func validPrefix(p string) bool {
+	if len(p) < minLookupPrefix || len(p) > maxLookupPrefix || p != strings.ToLower(p) {
+		return false
+	}
+	if len(p)%2 == 1 {
+		p += "0" // hex.DecodeString needs whole bytes
+	}
+	_, err := hex.DecodeString(p)
+	return err == nil
+}
+
+func (h *LookupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
+
+	var req LookupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err.Error() == "http: request body too large" {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		}
+		return
+	}
+
+	if len(req.Prefixes) > h.MaxItems {
+		http.Error(w, "Too many items in request", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	distinct := make(map[string]bool, len(req.Prefixes))
+	for _, prefix := range req.Prefixes {
+		if !validPrefix(prefix) {
+			http.Error(w, "Invalid prefix: want 4-8 lower-case hex characters", http.StatusBadRequest)
+			return
+		}
+		distinct[prefix] = true
+	}
+	if h.Limiter != nil && !h.Limiter.AllowN(r, len(distinct)) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many lookups. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	resp := LookupResponse{Matches: make(map[string]map[string]*LookupResult)}
+	for _, prefix := range req.Prefixes {
+		if _, done := resp.Matches[prefix]; done {
+			continue
+		}
+		// Ask for one extra to know whether the client should use a longer prefix
+		matches := h.cache.GetByHashPrefix(prefix, h.MaxMatches+1)
+		if len(matches) > h.MaxMatches {
+			resp.Truncated = append(resp.Truncated, prefix)
+			for hash := range matches {
+				delete(matches, hash)
+				break
+			}
+		}
+		results := make(map[string]*LookupResult, len(matches))
+		for hash, res := range matches {
+			results[hash] = newLookupResult(res)
+		}
+		resp.Matches[prefix] = results
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("Error encoding lookup response", "error", err)
+	}
+}
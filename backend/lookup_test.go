@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sph/youtube-url-replacer/backend/middleware"
+	"github.com/sph/youtube-url-replacer/backend/resolvers"
+)
+
+func TestLookupHandler(t *testing.T) {
+	cache := NewInMemoryCache()
+	cache.Set("https://example.com/a", &resolvers.Result{Title: "A"})
+	cache.Set("https://example.com/b", &resolvers.Result{Title: "B"})
+	h := NewLookupHandler(cache.(resolvers.HashPrefixCache))
+
+	lookup := func(body string) (*httptest.ResponseRecorder, LookupResponse) {
+		req := httptest.NewRequest("POST", "/lookup", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		var resp LookupResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Invalid response: %v", err)
+			}
+		}
+		return w, resp
+	}
+
+	t.Run("Prefix Match", func(t *testing.T) {
+		hash := resolvers.HashKey("https://example.com/a")
+		w, resp := lookup(fmt.Sprintf(`{"prefixes": [%q]}`, hash[:5]))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		if res := resp.Matches[hash[:5]][hash]; res == nil || res.Title != "A" {
+			t.Errorf("Expected the cached result under its full hash, got %+v", resp.Matches)
+		}
+	})
+
+	t.Run("Leaves Out URLs", func(t *testing.T) {
+		key := "https://example.com/private?token=secret"
+		cache.Set(key, &resolvers.Result{
+			Title:         "Private",
+			FinalURL:      key,
+			CleanURL:      key,
+			CanonicalURL:  key,
+			RedirectChain: []resolvers.Hop{{URL: key, Status: 200}},
+			Validators:    &resolvers.Validators{Resolver: "opengraph", URL: key, ETag: `"v1"`},
+		})
+		hash := resolvers.HashKey(key)
+		req := httptest.NewRequest("POST", "/lookup", strings.NewReader(fmt.Sprintf(`{"prefixes": [%q]}`, hash[:4])))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Private") {
+			t.Fatalf("Expected the cached title, got %d %s", w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "example.com") {
+			t.Errorf("Expected no URLs in the response, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("Rate Limited Per Prefix", func(t *testing.T) {
+		h.Limiter = middleware.NewRateLimiter(60, 3)
+		defer func() { h.Limiter = nil }()
+		if w, _ := lookup(`{"prefixes": ["abcd", "abce", "abcd"]}`); w.Code != http.StatusOK {
+			t.Fatalf("Expected 2 distinct prefixes to fit a burst of 3, got %d", w.Code)
+		}
+		if w, _ := lookup(`{"prefixes": ["abcf", "abd0"]}`); w.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected 429 once the prefix budget is spent, got %d", w.Code)
+		}
+		if w, _ := lookup(`{"prefixes": ["abcf"]}`); w.Code != http.StatusOK {
+			t.Errorf("Expected the remaining token to be usable, got %d", w.Code)
+		}
+	})
+
+	t.Run("No Match", func(t *testing.T) {
+		prefix := "0000"
+		for _, key := range []string{"https://example.com/a", "https://example.com/b"} {
+			if strings.HasPrefix(resolvers.HashKey(key), prefix) {
+				t.Skip("Test keys collide with the prefix")
+			}
+		}
+		w, resp := lookup(`{"prefixes": ["0000"]}`)
+		if w.Code != http.StatusOK || len(resp.Matches["0000"]) != 0 {
+			t.Errorf("Expected an empty match set, got %d %+v", w.Code, resp.Matches)
+		}
+	})
+
+	t.Run("Invalid Prefixes", func(t *testing.T) {
+		for _, p := range []string{"abc", "ABCD", "abcdef012", "zzzz"} {
+			w, _ := lookup(fmt.Sprintf(`{"prefixes": [%q]}`, p))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %q, got %d", p, w.Code)
+			}
+		}
+	})
+
+	t.Run("Truncation", func(t *testing.T) {
+		// Find three keys sharing a four-digit hash prefix
+		many := NewInMemoryCache()
+		buckets := make(map[string]int)
+		var prefix string
+		for i := 0; prefix == ""; i++ {
+			key := fmt.Sprintf("https://example.com/%d", i)
+			many.Set(key, &resolvers.Result{Title: key})
+			p := resolvers.HashKey(key)[:4]
+			if buckets[p]++; buckets[p] == 3 {
+				prefix = p
+			}
+		}
+		h := NewLookupHandler(many.(resolvers.HashPrefixCache))
+		h.MaxMatches = 2
+
+		req := httptest.NewRequest("POST", "/lookup", strings.NewReader(fmt.Sprintf(`{"prefixes": [%q]}`, prefix)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		var resp LookupResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Matches[prefix]) != 2 || len(resp.Truncated) != 1 || resp.Truncated[0] != prefix {
+			t.Errorf("Expected 2 matches and a truncation flag, got %d matches, truncated %v", len(resp.Matches[prefix]), resp.Truncated)
+		}
+	})
+
+	t.Run("Too Many Prefixes", func(t *testing.T) {
+		h.MaxItems = 1
+		defer func() { h.MaxItems = 50 }()
+		w, _ := lookup(`{"prefixes": ["abcd", "abce"]}`)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413, got %d", w.Code)
+		}
+	})
+}
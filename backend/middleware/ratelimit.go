@@ -84,6 +84,13 @@ func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	})
 }
 
+// AllowN spends n tokens from the bucket of r's client, for handlers whose
+// cost grows with the size of the request. It reports false, spending
+// nothing, when the bucket doesn't hold n tokens.
+func (rl *RateLimiter) AllowN(r *http.Request, n int) bool {
+	return rl.getVisitor(getIP(r)).AllowN(time.Now(), n)
+}
+
 func getIP(r *http.Request) string {
 	// 1. Check X-Forwarded-For (Cloud Run / Load Balancers)
 	forwarded := r.Header.Get("X-Forwarded-For")
@@ -66,3 +66,19 @@ func TestCleanup(t *testing.T) {
 	// For unit test, it's hard to test the goroutine deterministically without hooks.
 	// We'll skip complex async testing here and trust the sync.Map logic.
 }
+
+func TestRateLimiter_AllowN(t *testing.T) {
+	rl := NewRateLimiter(60, 10)
+	req := httptest.NewRequest("POST", "/", nil)
+	req.RemoteAddr = "1.2.3.4:1234"
+
+	if !rl.AllowN(req, 8) {
+		t.Error("Expected 8 tokens to fit in a burst of 10")
+	}
+	if rl.AllowN(req, 8) {
+		t.Error("Expected the second batch of 8 to be refused")
+	}
+	if !rl.AllowN(req, 2) {
+		t.Error("Expected a refused batch to leave the remaining tokens")
+	}
+}
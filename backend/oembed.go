@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sph/youtube-url-replacer/backend/resolvers"
+)
+
+// Default embed size for "video" responses (16:9)
+const (
+	defaultEmbedWidth  = 640
+	defaultEmbedHeight = 360
+)
+
+// OEmbedResponse is an oEmbed 1.0 provider response
+// (https://oembed.com/#section2.3). Only the "link" and "video" types are produced.
+type OEmbedResponse struct {
+	Type            string `json:"type"`
+	Version         string `json:"version"`
+	Title           string `json:"title,omitempty"`
+	AuthorName      string `json:"author_name,omitempty"`
+	ProviderName    string `json:"provider_name,omitempty"`
+	ProviderURL     string `json:"provider_url,omitempty"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+	HTML            string `json:"html,omitempty"`
+	Width           int    `json:"width,omitempty"`
+	Height          int    `json:"height,omitempty"`
+}
+
+// OEmbedHandler exposes ResolverManager results as an oEmbed provider endpoint
+type OEmbedHandler struct {
+	manager *resolvers.ResolverManager
+}
+
+func NewOEmbedHandler(manager *resolvers.ResolverManager) *OEmbedHandler {
+	return &OEmbedHandler{manager: manager}
+}
+
+func (h *OEmbedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+
+	// The spec only requires json; xml consumers get 501 Not Implemented
+	if format := q.Get("format"); format != "" && format != "json" {
+		http.Error(w, "Format not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	rawURL := q.Get("url")
+	target, err := url.Parse(rawURL)
+	if rawURL == "" || err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "Missing or invalid url parameter", http.StatusBadRequest)
+		return
+	}
+
+	maxWidth, err := parseDimension(q.Get("maxwidth"))
+	if err != nil {
+		http.Error(w, "Invalid maxwidth", http.StatusBadRequest)
+		return
+	}
+	maxHeight, err := parseDimension(q.Get("maxheight"))
+	if err != nil {
+		http.Error(w, "Invalid maxheight", http.StatusBadRequest)
+		return
+	}
+
+	res := h.manager.ResolveMulti(r.Context(), []string{rawURL})[rawURL]
+	if res == nil || res.Title == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(buildOEmbed(target, res, maxWidth, maxHeight)); err != nil {
+		slog.Error("Error encoding oEmbed response", "error", err)
+	}
+}
+
+// parseDimension parses maxwidth/maxheight; zero means unconstrained
+func parseDimension(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return v, nil
+}
+
+// fitWithin scales w x h down (never up) to respect the consumer's maximums.
+// Neither side rounds down to 0, which would drop it from a video response.
+func fitWithin(w, h, maxWidth, maxHeight int) (int, int) {
+	if maxWidth > 0 && w > maxWidth {
+		h = max(1, h*maxWidth/w)
+		w = maxWidth
+	}
+	if maxHeight > 0 && h > maxHeight {
+		w = max(1, w*maxHeight/h)
+		h = maxHeight
+	}
+	return w, h
+}
+
+func buildOEmbed(target *url.URL, res *resolvers.Result, maxWidth, maxHeight int) *OEmbedResponse {
+	out := &OEmbedResponse{
+		Type:         "link",
+		Version:      "1.0",
+		Title:        res.Title,
+		AuthorName:   res.Author,
+		ProviderName: res.SiteName,
+	}
+	if out.ProviderName == "" && res.Platform != "Generic" {
+		out.ProviderName = res.Platform
+	}
+
+	origin := target
+	if res.FinalURL != "" {
+		if u, err := url.Parse(res.FinalURL); err == nil {
+			origin = u
+		}
+	}
+	out.ProviderURL = origin.Scheme + "://" + origin.Host + "/"
+
+	// Thumbnails must carry all three fields and must not exceed the requested maximums
+	if img := res.Image; img != nil && img.Width > 0 && img.Height > 0 {
+		if (maxWidth == 0 || img.Width <= maxWidth) && (maxHeight == 0 || img.Height <= maxHeight) {
+			out.ThumbnailURL = img.URL
+			out.ThumbnailWidth = img.Width
+			out.ThumbnailHeight = img.Height
+		}
+	}
+
+	if videoID := youTubeVideoID(res); videoID != "" {
+		width, height := fitWithin(defaultEmbedWidth, defaultEmbedHeight, maxWidth, maxHeight)
+		out.Type = "video"
+		out.Width = width
+		out.Height = height
+		out.HTML = `<iframe width="` + strconv.Itoa(width) + `" height="` + strconv.Itoa(height) +
+			`" src="https://www.youtube-nocookie.com/embed/` + url.PathEscape(videoID) +
+			`" frameborder="0" allow="encrypted-media; picture-in-picture" allowfullscreen></iframe>`
+	}
+
+	return out
+}
+
+// youTubeVideoID returns the video ID when the result is an embeddable YouTube video
+func youTubeVideoID(res *resolvers.Result) string {
+	if res.Platform != "YouTube" || res.CanonicalURL == "" {
+		return ""
+	}
+	u, err := url.Parse(res.CanonicalURL)
+	if err != nil || !strings.HasSuffix(u.Hostname(), "youtube.com") {
+		return ""
+	}
+	return u.Query().Get("v")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sph/youtube-url-replacer/backend/resolvers"
+)
+
+type stubResolver struct {
+	results map[string]*resolvers.Result
+}
+
+func (s *stubResolver) Name() string              { return "stub" }
+func (s *stubResolver) CanHandle(u *url.URL) bool { return s.results[u.String()] != nil }
+func (s *stubResolver) Resolve(ctx context.Context, u *url.URL) (*resolvers.Result, error) {
+	return s.results[u.String()], nil
+}
+
+func TestOEmbedHandler(t *testing.T) {
+	cache := NewInMemoryCache()
+	manager := resolvers.NewResolverManager(cache)
+	manager.Register(&stubResolver{results: map[string]*resolvers.Result{
+		"https://example.com/article": {
+			Title:    "An Article",
+			Platform: "Generic",
+			SiteName: "Example",
+			Author:   "Jane",
+			FinalURL: "https://www.example.com/article",
+			Image:    &resolvers.Image{URL: "https://example.com/a.png", Width: 1200, Height: 630},
+		},
+		"https://youtu.be/abc": {
+			Title:        "A Video",
+			Platform:     "YouTube",
+			SiteName:     "YouTube",
+			CanonicalURL: "https://www.youtube.com/watch?v=abc",
+			Image:        &resolvers.Image{URL: "https://i.ytimg.com/vi/abc/hqdefault.jpg", Width: 480, Height: 360},
+		},
+	}})
+	h := NewOEmbedHandler(manager)
+
+	get := func(query string) (*httptest.ResponseRecorder, OEmbedResponse) {
+		req := httptest.NewRequest("GET", "/oembed?"+query, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		var out OEmbedResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&out); err != nil {
+				t.Fatalf("Invalid JSON: %v", err)
+			}
+		}
+		return w, out
+	}
+
+	t.Run("Link", func(t *testing.T) {
+		w, out := get("url=" + url.QueryEscape("https://example.com/article") + "&format=json")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		if out.Type != "link" || out.Version != "1.0" || out.Title != "An Article" {
+			t.Errorf("Unexpected response %+v", out)
+		}
+		if out.AuthorName != "Jane" || out.ProviderName != "Example" || out.ProviderURL != "https://www.example.com/" {
+			t.Errorf("Unexpected provider fields %+v", out)
+		}
+		if out.ThumbnailURL != "https://example.com/a.png" || out.ThumbnailWidth != 1200 {
+			t.Errorf("Expected thumbnail, got %+v", out)
+		}
+	})
+
+	t.Run("Thumbnail Exceeds Max", func(t *testing.T) {
+		_, out := get("url=" + url.QueryEscape("https://example.com/article") + "&maxwidth=300")
+		if out.ThumbnailURL != "" || out.ThumbnailWidth != 0 || out.ThumbnailHeight != 0 {
+			t.Errorf("Expected thumbnail to be dropped, got %+v", out)
+		}
+	})
+
+	t.Run("Video", func(t *testing.T) {
+		_, out := get("url=" + url.QueryEscape("https://youtu.be/abc") + "&maxwidth=320&maxheight=400")
+		if out.Type != "video" {
+			t.Fatalf("Expected video type, got %+v", out)
+		}
+		if out.Width != 320 || out.Height != 180 {
+			t.Errorf("Expected 320x180 embed, got %dx%d", out.Width, out.Height)
+		}
+		if !strings.Contains(out.HTML, "youtube-nocookie.com/embed/abc") || !strings.Contains(out.HTML, `width="320"`) {
+			t.Errorf("Unexpected html %q", out.HTML)
+		}
+	})
+
+	t.Run("Video In A Tiny Box", func(t *testing.T) {
+		_, out := get("url=" + url.QueryEscape("https://youtu.be/abc") + "&maxwidth=1")
+		if out.Type != "video" || out.Width != 1 || out.Height != 1 {
+			t.Errorf("Expected a 1x1 embed, got %+v", out)
+		}
+	})
+
+	t.Run("Video From Cache", func(t *testing.T) {
+		// The second lookup is served from the cache and must still be a video
+		_, out := get("url=" + url.QueryEscape("https://youtu.be/abc"))
+		if out.Type != "video" || out.Width != 640 || out.Height != 360 {
+			t.Errorf("Expected cached video response, got %+v", out)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			query string
+			code  int
+		}{
+			{"", http.StatusBadRequest},
+			{"url=ftp://example.com/file", http.StatusBadRequest},
+			{"url=https://example.com/article&format=xml", http.StatusNotImplemented},
+			{"url=https://example.com/article&maxwidth=abc", http.StatusBadRequest},
+			{"url=https://unknown.example/", http.StatusNotFound},
+		}
+		for _, tc := range tests {
+			if w, _ := get(tc.query); w.Code != tc.code {
+				t.Errorf("GET /oembed?%s = %d; want %d", tc.query, w.Code, tc.code)
+			}
+		}
+
+		req := httptest.NewRequest("POST", "/oembed?url=https://example.com/article", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405 for POST, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package reputation
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// hostsFileIgnored are the loopback aliases every hosts file starts with
+var hostsFileIgnored = map[string]bool{
+	"localhost": true, "localhost.localdomain": true, "local": true, "broadcasthost": true,
+	"ip6-localhost": true, "ip6-loopback": true, "ip6-localnet": true, "ip6-mcastprefix": true,
+	"ip6-allnodes": true, "ip6-allrouters": true, "ip6-allhosts": true, "0.0.0.0": true,
+}
+
+// loadFeed reads and parses a feed file
+func loadFeed(f Feed) (*entries, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	e := newEntries()
+	switch f.Format {
+	case FormatURLhaus:
+		err = parseURLhaus(file, f.Verdict, e)
+	case FormatHosts:
+		err = parseLines(file, func(line string) {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				return
+			}
+			for _, h := range fields[1:] {
+				addHost(e, h, f.Verdict)
+			}
+		})
+	case FormatDomains:
+		err = parseLines(file, func(line string) {
+			addHost(e, line, f.Verdict)
+		})
+	case FormatHashPrefix:
+		err = parseLines(file, func(line string) {
+			p := strings.ToLower(line)
+			// Shorter than 4 bytes matches far too much to be meaningful
+			if _, decodeErr := hex.DecodeString(p); decodeErr != nil || len(p) < 8 || len(p) > 64 {
+				return
+			}
+			e.prefixes[p] = true
+			e.prefixLength[len(p)] = true
+		})
+	default:
+		return nil, fmt.Errorf("unknown format %q", f.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(e.urls) == 0 && len(e.hosts) == 0 && len(e.prefixes) == 0 {
+		return nil, errors.New("no entries found")
+	}
+	return e, nil
+}
+
+// parseLines calls fn for every non-empty line with "#" comments removed
+func parseLines(r io.Reader, fn func(line string)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// addHost accepts plain domains as well as "*.domain" and adblock "||domain^" rules
+func addHost(e *entries, h string, v Verdict) {
+	h = strings.ToLower(strings.TrimSpace(h))
+	h = strings.TrimPrefix(h, "||")
+	h = strings.TrimSuffix(h, "^")
+	h = strings.TrimPrefix(h, "*.")
+	h = strings.TrimSuffix(h, ".")
+	if h == "" || hostsFileIgnored[h] || strings.ContainsAny(h, "/ ") {
+		return
+	}
+	e.hosts[h] = Worse(e.hosts[h], v)
+}
+
+// parseURLhaus reads the URLhaus CSV export. Listed URLs get the feed's
+// verdict while online and Suspicious once offline; their hosts are only
+// Suspicious, since one payload on a shared host doesn't condemn the host.
+func parseURLhaus(r io.Reader, v Verdict, e *entries) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 4 {
+			continue
+		}
+		u, err := url.Parse(strings.TrimSpace(record[2]))
+		if err != nil || u.Host == "" {
+			continue // Header row or garbage
+		}
+		verdict := v
+		if strings.TrimSpace(record[3]) != "online" {
+			verdict = Suspicious
+		}
+		key := normalizeURL(u)
+		e.urls[key] = Worse(e.urls[key], verdict)
+		addHost(e, u.Hostname(), Suspicious)
+	}
+}
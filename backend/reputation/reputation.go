@@ -0,0 +1,329 @@
+// Package reputation checks URLs against threat feeds loaded from local files.
+// Nothing is looked up over the network: feeds are downloaded by whatever
+// process the operator prefers and picked up here when the files change.
+package reputation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Verdict is the reputation of a URL
+type Verdict string
+
+const (
+	Clean      Verdict = "clean"
+	Suspicious Verdict = "suspicious"
+	Malicious  Verdict = "malicious"
+)
+
+func (v Verdict) rank() int {
+	switch v {
+	case Malicious:
+		return 2
+	case Suspicious:
+		return 1
+	}
+	return 0
+}
+
+// Worse returns the more severe of two verdicts
+func Worse(a, b Verdict) Verdict {
+	if b.rank() > a.rank() {
+		return b
+	}
+	return a
+}
+
+// Format is the on-disk layout of a feed
+type Format string
+
+const (
+	FormatURLhaus    Format = "urlhaus"    // URLhaus CSV export (id,dateadded,url,url_status,...)
+	FormatHosts      Format = "hosts"      // hosts file: "0.0.0.0 evil.example"
+	FormatDomains    Format = "domains"    // One domain per line
+	FormatHashPrefix Format = "hashprefix" // Hex SHA-256 prefixes of Safe Browsing URL expressions
+)
+
+// Feed describes one blocklist file
+type Feed struct {
+	Format Format
+	Path   string
+	// Verdict assigned to entries; URLhaus entries that are offline are downgraded to Suspicious
+	Verdict Verdict
+}
+
+// defaultVerdicts reflect how much a match can be trusted: a hash prefix
+// match can't be confirmed offline, so it only makes the URL suspicious
+var defaultVerdicts = map[Format]Verdict{
+	FormatURLhaus:    Malicious,
+	FormatHosts:      Malicious,
+	FormatDomains:    Malicious,
+	FormatHashPrefix: Suspicious,
+}
+
+// ParseFeed parses a feed spec of the form "format[=verdict]:path",
+// e.g. "urlhaus:/data/urlhaus.csv" or "domains=suspicious:/data/newly-registered.txt"
+func ParseFeed(spec string) (Feed, error) {
+	kind, path, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok || path == "" {
+		return Feed{}, fmt.Errorf("invalid feed %q: want format:path", spec)
+	}
+	format, verdict, _ := strings.Cut(kind, "=")
+	f := Feed{Format: Format(strings.ToLower(format)), Path: path}
+
+	def, known := defaultVerdicts[f.Format]
+	if !known {
+		return Feed{}, fmt.Errorf("invalid feed %q: unknown format %q", spec, format)
+	}
+	switch Verdict(strings.ToLower(verdict)) {
+	case "":
+		f.Verdict = def
+	case Malicious, Suspicious:
+		f.Verdict = Verdict(strings.ToLower(verdict))
+	default:
+		return Feed{}, fmt.Errorf("invalid feed %q: unknown verdict %q", spec, verdict)
+	}
+	return f, nil
+}
+
+// entries is the parsed content of one feed
+type entries struct {
+	urls         map[string]Verdict // Normalized URL -> verdict
+	hosts        map[string]Verdict // Host or domain -> verdict; subdomains match too
+	prefixes     map[string]bool    // Lower-case hex hash prefixes
+	prefixLength map[int]bool       // Distinct prefix lengths, in hex characters
+}
+
+func newEntries() *entries {
+	return &entries{
+		urls:         make(map[string]Verdict),
+		hosts:        make(map[string]Verdict),
+		prefixes:     make(map[string]bool),
+		prefixLength: make(map[int]bool),
+	}
+}
+
+type feedState struct {
+	feed    Feed
+	modTime time.Time
+	entries *entries
+}
+
+// DB holds the loaded feeds. Reload swaps in a feed's new entries only when
+// its file parsed successfully, so a truncated download keeps the last good copy.
+type DB struct {
+	mu    sync.RWMutex
+	feeds []*feedState
+}
+
+// NewDB creates a database over the given feeds; call Reload to load them
+func NewDB(feeds ...Feed) *DB {
+	db := &DB{}
+	for _, f := range feeds {
+		db.feeds = append(db.feeds, &feedState{feed: f})
+	}
+	return db
+}
+
+// Reload re-reads every feed whose file changed since the last load. Errors
+// are collected per feed; feeds that fail keep their previous entries.
+func (db *DB) Reload() error {
+	var errs []string
+	for i := range db.feeds {
+		db.mu.RLock()
+		state := db.feeds[i]
+		db.mu.RUnlock()
+
+		info, err := os.Stat(state.feed.Path)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if state.entries != nil && info.ModTime().Equal(state.modTime) {
+			continue
+		}
+
+		e, err := loadFeed(state.feed)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", state.feed.Path, err))
+			continue
+		}
+
+		db.mu.Lock()
+		db.feeds[i] = &feedState{feed: state.feed, modTime: info.ModTime(), entries: e}
+		db.mu.Unlock()
+		slog.Info("Loaded reputation feed", "path", state.feed.Path, "format", state.feed.Format,
+			"urls", len(e.urls), "hosts", len(e.hosts), "prefixes", len(e.prefixes))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("reputation reload: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Start reloads the feeds every interval until ctx is cancelled
+func (db *DB) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := db.Reload(); err != nil {
+					slog.Warn("Reputation feed reload failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Check returns the worst verdict any feed gives the URL
+func (db *DB) Check(u *url.URL) Verdict {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	normalized := normalizeURL(u)
+	var hashes []string
+	verdict := Clean
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, state := range db.feeds {
+		e := state.entries
+		if e == nil {
+			continue
+		}
+		if v, ok := e.urls[normalized]; ok {
+			verdict = Worse(verdict, v)
+		}
+		for _, h := range hostSuffixes(host) {
+			if v, ok := e.hosts[h]; ok {
+				verdict = Worse(verdict, v)
+			}
+		}
+		if len(e.prefixes) > 0 {
+			if hashes == nil {
+				hashes = expressionHashes(u)
+			}
+			for _, sum := range hashes {
+				for n := range e.prefixLength {
+					if n <= len(sum) && e.prefixes[sum[:n]] {
+						verdict = Worse(verdict, state.feed.Verdict)
+					}
+				}
+			}
+		}
+	}
+	return verdict
+}
+
+// IsMalicious reports whether the URL's host or the URL itself is known-malicious
+func (db *DB) IsMalicious(u *url.URL) bool {
+	return db.Check(u) == Malicious
+}
+
+// normalizeURL is the form URLs are compared in: lower-case scheme and host,
+// no default port, no fragment
+func normalizeURL(u *url.URL) string {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+	host, port := strings.TrimSuffix(strings.ToLower(c.Hostname()), "."), c.Port()
+	if (c.Scheme == "http" && port == "80") || (c.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		c.Host = net.JoinHostPort(host, port)
+	} else {
+		c.Host = host
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	c.User = nil
+	c.Fragment = ""
+	c.RawFragment = ""
+	return c.String()
+}
+
+// hostSuffixes returns the host and each parent domain, so a listed
+// evil.example also covers cdn.evil.example. IP addresses match only exactly.
+func hostSuffixes(host string) []string {
+	if host == "" {
+		return nil
+	}
+	if net.ParseIP(host) != nil {
+		return []string{host}
+	}
+	suffixes := []string{host}
+	for i := strings.Index(host, "."); i >= 0; i = strings.Index(host, ".") {
+		host = host[i+1:]
+		if !strings.Contains(host, ".") {
+			break // Never match a bare TLD
+		}
+		suffixes = append(suffixes, host)
+	}
+	return suffixes
+}
+
+// expressionHashes returns the hex SHA-256 of each host-suffix/path-prefix
+// expression of u, following the Safe Browsing URL hashing scheme:
+// up to five host suffixes times up to six path prefixes.
+func expressionHashes(u *url.URL) []string {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return nil
+	}
+
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		labels := strings.Split(host, ".")
+		// The last five components, dropping one at a time down to two
+		start := max(1, len(labels)-5)
+		for i := start; i <= len(labels)-2 && len(hosts) < 5; i++ {
+			hosts = append(hosts, strings.Join(labels[i:], "."))
+		}
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	paths := []string{}
+	if u.RawQuery != "" {
+		paths = append(paths, path+"?"+u.RawQuery)
+	}
+	paths = append(paths, path)
+	// Directory prefixes: "/", "/a/", "/a/b/" up to four components
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	prefix := "/"
+	paths = append(paths, prefix)
+	for i := 0; i < len(parts)-1 && i < 3; i++ {
+		prefix += parts[i] + "/"
+		paths = append(paths, prefix)
+	}
+
+	seen := make(map[string]bool)
+	var sums []string
+	for _, h := range hosts {
+		for _, p := range paths {
+			expr := h + p
+			if seen[expr] {
+				continue
+			}
+			seen[expr] = true
+			sum := sha256.Sum256([]byte(expr))
+			sums = append(sums, hex.EncodeToString(sum[:]))
+		}
+	}
+	return sums
+}
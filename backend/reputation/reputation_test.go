@@ -0,0 +1,134 @@
+package reputation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFeed(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func check(t *testing.T, db *DB, raw string) Verdict {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.Check(u)
+}
+
+func TestDB_Formats(t *testing.T) {
+	dir := t.TempDir()
+
+	urlhaus := writeFeed(t, dir, "urlhaus.csv", `################################################################
+# abuse.ch URLhaus Database Dump (CSV)                         #
+# id,dateadded,url,url_status,last_online,threat,tags,urlhaus_link,reporter
+"1","2024-01-01 00:00:00","http://payload.example.net/bin.exe","online","2024-01-01 00:00:00","malware_download","exe","https://urlhaus.abuse.ch/url/1/","x"
+"2","2024-01-01 00:00:00","http://old.example.org/a.sh","offline","","malware_download","sh","https://urlhaus.abuse.ch/url/2/","x"
+`)
+	hosts := writeFeed(t, dir, "hosts", `127.0.0.1 localhost
+::1 ip6-localhost
+0.0.0.0 0.0.0.0
+0.0.0.0 ads.tracker.test # ads
+0.0.0.0 phish.test
+`)
+	domains := writeFeed(t, dir, "domains.txt", `# newly registered
+||fresh.test^
+*.wild.test
+`)
+	sum := sha256.Sum256([]byte("hashed.test/"))
+	prefixes := writeFeed(t, dir, "prefixes.txt", hex.EncodeToString(sum[:4])+"\nzz\n")
+
+	db := NewDB(
+		Feed{Format: FormatURLhaus, Path: urlhaus, Verdict: Malicious},
+		Feed{Format: FormatHosts, Path: hosts, Verdict: Malicious},
+		Feed{Format: FormatDomains, Path: domains, Verdict: Suspicious},
+		Feed{Format: FormatHashPrefix, Path: prefixes, Verdict: Suspicious},
+	)
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	tests := []struct {
+		url  string
+		want Verdict
+	}{
+		{"http://payload.example.net/bin.exe", Malicious},
+		{"HTTP://PAYLOAD.example.net:80/bin.exe#x", Malicious},
+		{"http://payload.example.net/other", Suspicious}, // Host of a listed URL
+		{"http://old.example.org/a.sh", Suspicious},      // Offline
+		{"https://phish.test/login", Malicious},
+		{"https://cdn.phish.test/", Malicious}, // Subdomains match
+		{"https://ads.tracker.test/", Malicious},
+		{"https://tracker.test/", Clean}, // Parents don't
+		{"https://fresh.test/", Suspicious},
+		{"https://a.wild.test/", Suspicious},
+		{"https://hashed.test/any/path?q=1", Suspicious},
+		{"https://www.hashed.test/", Suspicious},
+		{"http://localhost/", Clean},
+		{"https://example.com/", Clean},
+	}
+	for _, tt := range tests {
+		if got := check(t, db, tt.url); got != tt.want {
+			t.Errorf("Check(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDB_Reload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFeed(t, dir, "domains.txt", "first.test\n")
+	db := NewDB(Feed{Format: FormatDomains, Path: path, Verdict: Malicious})
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A changed file replaces the previous entries
+	writeFeed(t, dir, "domains.txt", "second.test\n")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if check(t, db, "https://first.test/") != Clean || check(t, db, "https://second.test/") != Malicious {
+		t.Error("Expected reload to pick up the new list")
+	}
+
+	// An empty or broken file keeps the last good copy
+	writeFeed(t, dir, "domains.txt", "# nothing\n")
+	future = future.Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if err := db.Reload(); err == nil {
+		t.Error("Expected an error for an empty feed")
+	}
+	if check(t, db, "https://second.test/") != Malicious {
+		t.Error("Expected previous entries to survive a failed reload")
+	}
+}
+
+func TestParseFeed(t *testing.T) {
+	f, err := ParseFeed("hashprefix:/data/p.txt")
+	if err != nil || f.Format != FormatHashPrefix || f.Path != "/data/p.txt" || f.Verdict != Suspicious {
+		t.Errorf("Unexpected feed %+v (%v)", f, err)
+	}
+	f, err = ParseFeed("domains=suspicious:/data/d.txt")
+	if err != nil || f.Verdict != Suspicious {
+		t.Errorf("Unexpected feed %+v (%v)", f, err)
+	}
+	for _, bad := range []string{"/data/d.txt", "rss:/x", "domains=clean:/x"} {
+		if _, err := ParseFeed(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package resolvers
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Canonicalizer is implemented by resolvers that can reduce the URLs they
+// handle to a single canonical form (e.g. youtu.be/X and youtube.com/watch?v=X&t=30)
+type Canonicalizer interface {
+	Canonicalize(u *url.URL) (*url.URL, bool)
+}
+
+// trackerRule removes query parameters that only identify the click, not the resource
+type trackerRule struct {
+	hosts    []string // Registrable domains the rule is limited to; empty means every host
+	params   []string
+	prefixes []string
+}
+
+var defaultTrackerRules = []trackerRule{
+	{
+		prefixes: []string{"utm_", "__hs", "_hs", "pk_", "mtm_"},
+		params: []string{
+			"fbclid", "gclid", "gclsrc", "dclid", "gbraid", "wbraid", "msclkid", "yclid", "twclid",
+			"ttclid", "li_fat_id", "mc_cid", "mc_eid", "mkt_tok", "igshid", "igsh", "_ga", "_gl",
+			"oly_anon_id", "oly_enc_id", "vero_id", "vero_conv", "wickedid", "rb_clickid", "s_cid",
+			"hsctatracking", "ref_src", "ref_url",
+		},
+	},
+	{hosts: []string{"youtube.com", "youtu.be"}, params: []string{"si", "feature", "pp", "ab_channel"}},
+	{hosts: []string{"spotify.com"}, params: []string{"si", "context", "nd"}},
+	{hosts: []string{"twitter.com", "x.com"}, params: []string{"s", "t"}},
+	{hosts: []string{"instagram.com"}, params: []string{"img_index"}},
+	{hosts: []string{"tiktok.com"}, params: []string{"_r", "_t", "is_from_webapp", "sender_device", "is_copy_url"}},
+	{hosts: []string{"reddit.com"}, params: []string{"share_id", "rdt"}},
+	{hosts: []string{"linkedin.com"}, params: []string{"trk", "trackingid", "lipi", "midtoken", "midsig"}},
+	{hosts: []string{"amazon.com", "amazon.co.uk", "amazon.de"}, params: []string{"content-id", "crid", "sprefix"}, prefixes: []string{"pd_rd_", "pf_rd_"}},
+}
+
+// isTracker reports whether a query parameter is a tracker on the given registrable domain
+func isTracker(domain, param string) bool {
+	param = strings.ToLower(param)
+	for _, rule := range defaultTrackerRules {
+		if len(rule.hosts) > 0 {
+			matched := false
+			for _, h := range rule.hosts {
+				if h == domain {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		for _, p := range rule.params {
+			if p == param {
+				return true
+			}
+		}
+		for _, p := range rule.prefixes {
+			if strings.HasPrefix(param, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CleanURL normalizes a URL without changing what it points to: lower-case
+// scheme and host, punycode hostnames, no default port or trailing dot, no
+// tracking parameters, sorted query and no fragment (hash-bang routes are kept).
+func CleanURL(u *url.URL) *url.URL {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+	if c.Opaque != "" {
+		return &c // javascript:, data: and friends have no host or query to clean
+	}
+	c.User = nil
+
+	host, port := c.Hostname(), c.Port()
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+	if (c.Scheme == "http" && port == "80") || (c.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		c.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		c.Host = "[" + host + "]"
+	} else {
+		c.Host = host
+	}
+
+	if c.Path == "" {
+		c.Path = "/"
+	}
+
+	if c.RawQuery != "" {
+		domain := registrableDomain(host)
+		q := c.Query()
+		for k := range q {
+			if isTracker(domain, k) {
+				q.Del(k)
+			}
+		}
+		c.RawQuery = q.Encode() // Encode sorts by key
+	}
+	c.ForceQuery = false
+
+	if !strings.HasPrefix(c.Fragment, "!") && !strings.HasPrefix(c.Fragment, "/") {
+		c.Fragment = ""
+		c.RawFragment = ""
+	}
+	return &c
+}
+
+// canonicalize cleans the URL and lets the first resolver that handles it
+// reduce it further to its canonical ID
+func (m *ResolverManager) canonicalize(u *url.URL) *url.URL {
+	clean := CleanURL(u)
+	for _, r := range m.resolvers {
+		c, ok := r.(Canonicalizer)
+		if !ok || !r.CanHandle(clean) {
+			continue
+		}
+		if canonical, ok := c.Canonicalize(clean); ok {
+			return canonical
+		}
+	}
+	return clean
+}
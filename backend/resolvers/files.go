@@ -0,0 +1,424 @@
+package resolvers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"  // Register GIF for image.DecodeConfig
+	_ "image/jpeg" // Register JPEG for image.DecodeConfig
+	_ "image/png"  // Register PNG for image.DecodeConfig
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// How much of a non-HTML body we are willing to inspect
+const (
+	fileHeadBytes = 256 * 1024
+	pdfTailBytes  = 64 * 1024
+)
+
+var (
+	pdfTitleRegex = regexp.MustCompile(`/Title\s*(\(|<)`)
+	xmpTitleRegex = regexp.MustCompile(`(?s)<dc:title>.*?<rdf:li[^>]*>(.*?)</rdf:li>`)
+)
+
+// resolveFile builds a Result for a non-HTML response (PDF, image, audio or an
+// opaque download). It closes resp before any follow-up request, so those don't
+// queue behind it for the origin's politeness slot.
+func (r *OpenGraphResolver) resolveFile(ctx context.Context, resp *http.Response, mediaType string) (*Result, error) {
+	finalURL := resp.Request.URL
+	// Peek at the magic bytes without consuming them for the readers below
+	body := bufio.NewReaderSize(resp.Body, riskSniffBytes)
+	head, _ := body.Peek(riskSniffBytes)
+
+	res := &Result{
+		Platform:    "File",
+		FinalURL:    finalURL.String(),
+		ContentType: mediaType,
+		FileName:    fileName(resp),
+		Risk:        classifyDownload(finalURL, mediaType, resp.Header.Get("Content-Disposition"), head),
+		Security:    describeTLS(resp.TLS),
+	}
+
+	var pdfHead []byte
+	switch {
+	case mediaType == "application/pdf":
+		var err error
+		if pdfHead, err = io.ReadAll(io.LimitReader(body, fileHeadBytes)); err != nil {
+			return nil, err
+		}
+		res.Title = pdfTitle(pdfHead)
+
+	case strings.HasPrefix(mediaType, "image/"):
+		cfg, format, err := image.DecodeConfig(io.LimitReader(body, fileHeadBytes))
+		if err == nil {
+			res.Image = &Image{URL: finalURL.String(), Width: cfg.Width, Height: cfg.Height}
+			res.Description = fmt.Sprintf("%s image, %d×%d", strings.ToUpper(format), cfg.Width, cfg.Height)
+		} else {
+			res.Image = &Image{URL: finalURL.String()}
+		}
+
+	case strings.HasPrefix(mediaType, "audio/"):
+		title, artist := readID3(io.LimitReader(body, fileHeadBytes))
+		res.Title = title
+		res.Author = artist
+	}
+
+	resp.Body.Close()
+	res.ContentLength = r.contentLength(ctx, resp)
+	// Non-linearized PDFs keep the info dictionary in the trailer
+	if pdfHead != nil && res.Title == "" && res.ContentLength > int64(len(pdfHead)) {
+		if tail, err := r.fetchRange(ctx, finalURL, fmt.Sprintf("bytes=-%d", pdfTailBytes)); err == nil {
+			res.Title = pdfTitle(tail)
+		}
+	}
+
+	if res.Title == "" {
+		res.Title = res.FileName
+	}
+	if res.Title == "" {
+		return nil, fmt.Errorf("no title found")
+	}
+
+	if res.Description == "" {
+		res.Description = describeFile(mediaType, res.ContentLength)
+	}
+	return res, nil
+}
+
+// fetchRange issues a ranged GET and returns at most the requested bytes
+func (r *OpenGraphResolver) fetchRange(ctx context.Context, u *url.URL, byteRange string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
+	req.Header.Set("Range", byteRange)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// A 200 means the server ignored the range; don't download the whole file
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("range not supported: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, pdfTailBytes))
+}
+
+// contentLength returns the size of the resource, asking for a single byte
+// with a ranged GET when the response doesn't declare its length
+func (r *OpenGraphResolver) contentLength(ctx context.Context, resp *http.Response) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		return totalFromContentRange(resp.Header.Get("Content-Range"))
+	}
+	if resp.ContentLength >= 0 && !resp.Uncompressed {
+		return resp.ContentLength
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", resp.Request.URL.String(), nil)
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
+	req.Header.Set("Range", "bytes=0-0")
+
+	rangeResp, err := r.client.Do(req)
+	if err != nil {
+		return 0
+	}
+	rangeResp.Body.Close()
+	if rangeResp.StatusCode != http.StatusPartialContent {
+		return 0
+	}
+	return totalFromContentRange(rangeResp.Header.Get("Content-Range"))
+}
+
+// totalFromContentRange parses the complete length from "bytes 0-0/12345"
+func totalFromContentRange(cr string) int64 {
+	i := strings.LastIndex(cr, "/")
+	if i < 0 {
+		return 0
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(cr[i+1:]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return total
+}
+
+// fileName prefers the Content-Disposition filename and falls back to the last path segment
+func fileName(resp *http.Response) string {
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		if _, params, err := mime.ParseMediaType(cd); err == nil && params["filename"] != "" {
+			return path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+		}
+	}
+	base := path.Base(resp.Request.URL.Path)
+	if base == "/" || base == "." {
+		return ""
+	}
+	return base
+}
+
+// describeFile returns a short human description like "PDF document · 1.2 MB"
+func describeFile(mediaType string, size int64) string {
+	kinds := map[string]string{
+		"application/pdf":               "PDF document",
+		"application/zip":               "ZIP archive",
+		"application/gzip":              "Gzip archive",
+		"application/x-tar":             "Tar archive",
+		"application/x-7z-compressed":   "7-Zip archive",
+		"application/vnd.rar":           "RAR archive",
+		"application/octet-stream":      "Binary file",
+		"application/x-msdownload":      "Windows executable",
+		"application/json":              "JSON document",
+		"application/xml":               "XML document",
+		"text/plain":                    "Text file",
+		"text/csv":                      "CSV file",
+		"application/msword":            "Word document",
+		"application/vnd.ms-excel":      "Excel spreadsheet",
+		"application/vnd.ms-powerpoint": "PowerPoint presentation",
+	}
+	kind, ok := kinds[mediaType]
+	switch {
+	case ok:
+	case strings.HasPrefix(mediaType, "image/"):
+		kind = "Image"
+	case strings.HasPrefix(mediaType, "audio/"):
+		kind = "Audio file"
+	case strings.HasPrefix(mediaType, "video/"):
+		kind = "Video file"
+	default:
+		kind = "File"
+	}
+	if size > 0 {
+		return kind + " · " + formatBytes(size)
+	}
+	return kind
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// pdfTitle looks for a title in the document info dictionary, then in XMP metadata
+func pdfTitle(data []byte) string {
+	if loc := pdfTitleRegex.FindSubmatchIndex(data); loc != nil {
+		if title := strings.TrimSpace(parsePDFString(data[loc[2]:])); title != "" {
+			return title
+		}
+	}
+	if m := xmpTitleRegex.FindSubmatch(data); m != nil {
+		return strings.TrimSpace(html.UnescapeString(string(m[1])))
+	}
+	return ""
+}
+
+// parsePDFString decodes a PDF literal "(...)" or hex "<...>" string starting at data[0]
+func parsePDFString(data []byte) string {
+	var out []byte
+	if data[0] == '<' {
+		end := bytes.IndexByte(data, '>')
+		if end < 0 {
+			return ""
+		}
+		hex := bytes.Map(func(r rune) rune {
+			if strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return r
+			}
+			return -1
+		}, data[1:end])
+		if len(hex)%2 == 1 {
+			hex = append(hex, '0')
+		}
+		for i := 0; i+1 < len(hex); i += 2 {
+			v, _ := strconv.ParseUint(string(hex[i:i+2]), 16, 8)
+			out = append(out, byte(v))
+		}
+		return decodePDFText(out)
+	}
+
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return decodePDFText(out)
+			}
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch e := data[i]; e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				continue // Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for j := 0; j < 3 && i < len(data) && data[i] >= '0' && data[i] <= '7'; j++ {
+						v = v*8 + int(data[i]-'0')
+						i++
+					}
+					i--
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return ""
+}
+
+// decodePDFText handles UTF-16BE strings (with BOM); everything else is treated as Latin-1
+func decodePDFText(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		return decodeUTF16(b[2:], binary.BigEndian)
+	}
+	return latin1(b)
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, order.Uint16(b[i:]))
+	}
+	return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return strings.TrimRight(string(r), "\x00")
+}
+
+// readID3 extracts the title and artist from an ID3v2 tag at the start of an audio file
+func readID3(rd io.Reader) (title, artist string) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(rd, header); err != nil || string(header[:3]) != "ID3" {
+		return "", ""
+	}
+	version := header[3]
+	size := syncsafe(header[6:10])
+	tag, err := io.ReadAll(io.LimitReader(rd, int64(size)))
+	if err != nil {
+		return "", ""
+	}
+
+	// Skip the extended header if present
+	if header[5]&0x40 != 0 && version >= 3 && len(tag) >= 4 {
+		extSize := int(binary.BigEndian.Uint32(tag[:4]))
+		if version == 4 {
+			extSize = syncsafe(tag[:4])
+		} else {
+			extSize += 4
+		}
+		if extSize > len(tag) {
+			return "", ""
+		}
+		tag = tag[extSize:]
+	}
+
+	idLen, headerLen := 4, 10
+	titleID, artistID := "TIT2", "TPE1"
+	if version == 2 {
+		idLen, headerLen = 3, 6
+		titleID, artistID = "TT2", "TP1"
+	}
+
+	for len(tag) >= headerLen && tag[0] != 0 {
+		id := string(tag[:idLen])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+		default:
+			frameSize = syncsafe(tag[4:8])
+		}
+		if frameSize <= 0 || headerLen+frameSize > len(tag) {
+			break
+		}
+		body := tag[headerLen : headerLen+frameSize]
+		switch id {
+		case titleID:
+			title = id3Text(body)
+		case artistID:
+			artist = id3Text(body)
+		}
+		tag = tag[headerLen+frameSize:]
+	}
+	return title, artist
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// id3Text decodes a text frame body according to its leading encoding byte
+func id3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	enc, data := b[0], b[1:]
+	var s string
+	switch enc {
+	case 1: // UTF-16 with BOM
+		if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+			s = decodeUTF16(data[2:], binary.LittleEndian)
+		} else if len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF {
+			s = decodeUTF16(data[2:], binary.BigEndian)
+		} else {
+			s = decodeUTF16(data, binary.LittleEndian)
+		}
+	case 2: // UTF-16BE
+		s = decodeUTF16(data, binary.BigEndian)
+	case 3: // UTF-8
+		s = string(data)
+	default: // ISO-8859-1
+		s = latin1(data)
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
@@ -0,0 +1,189 @@
+package resolvers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
+)
+
+// id3Frame builds an ID3v2.3 text frame with UTF-8 encoding
+func id3Frame(id, text string) []byte {
+	body := append([]byte{3}, text...)
+	frame := []byte(id)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(body)))
+	frame = append(frame, 0, 0)
+	return append(frame, body...)
+}
+
+func TestOpenGraphResolver_Files(t *testing.T) {
+	// A PDF whose info dictionary lives in the trailer, beyond the first read
+	pdf := "%PDF-1.4\n" + strings.Repeat("x", fileHeadBytes+1024) +
+		"\ntrailer << /Info << /Title (Annual Report \\(2024\\)) >> >>\n%%EOF"
+
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, image.NewRGBA(image.Rect(0, 0, 64, 48))); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := append(id3Frame("TIT2", "Song Title"), id3Frame("TPE1", "The Band")...)
+	mp3 := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(frames))}
+	mp3 = append(mp3, frames...)
+	mp3 = append(mp3, make([]byte, 512)...)
+
+	serve := func(contentType string, data []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/report.pdf", serve("application/pdf", []byte(pdf)))
+	mux.HandleFunc("/pic.png", serve("image/png", pngBuf.Bytes()))
+	mux.HandleFunc("/track.mp3", serve("audio/mpeg", mp3))
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="setup-files.zip"`)
+		serve("application/zip", make([]byte, 3*1024*1024))(w, r)
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	r := NewOpenGraphResolver()
+	r.SetEgressPolicy(transport.LocalPolicy())
+	ctx := context.Background()
+	resolve := func(p string) *Result {
+		t.Helper()
+		u, _ := url.Parse(ts.URL + p)
+		res, err := r.Resolve(ctx, u)
+		if err != nil {
+			t.Fatalf("Resolve(%s) failed: %v", p, err)
+		}
+		return res
+	}
+
+	t.Run("PDF", func(t *testing.T) {
+		res := resolve("/report.pdf")
+		if res.Title != "Annual Report (2024)" {
+			t.Errorf("Unexpected title %q", res.Title)
+		}
+		if res.ContentType != "application/pdf" || res.FileName != "report.pdf" {
+			t.Errorf("Unexpected type/name %q/%q", res.ContentType, res.FileName)
+		}
+		if !strings.HasPrefix(res.Description, "PDF document · ") {
+			t.Errorf("Unexpected description %q", res.Description)
+		}
+	})
+
+	t.Run("Image", func(t *testing.T) {
+		res := resolve("/pic.png")
+		if res.Title != "pic.png" || res.Description != "PNG image, 64×48" {
+			t.Errorf("Unexpected title/description %q/%q", res.Title, res.Description)
+		}
+		if res.Image == nil || res.Image.Width != 64 || res.Image.Height != 48 {
+			t.Errorf("Unexpected image %+v", res.Image)
+		}
+	})
+
+	t.Run("Audio", func(t *testing.T) {
+		res := resolve("/track.mp3")
+		if res.Title != "Song Title" || res.Author != "The Band" {
+			t.Errorf("Unexpected title/author %q/%q", res.Title, res.Author)
+		}
+	})
+
+	t.Run("Download", func(t *testing.T) {
+		res := resolve("/download")
+		if res.Title != "setup-files.zip" {
+			t.Errorf("Unexpected title %q", res.Title)
+		}
+		if res.ContentLength != 3*1024*1024 || res.Description != "ZIP archive · 3.0 MB" {
+			t.Errorf("Unexpected size/description %d/%q", res.ContentLength, res.Description)
+		}
+	})
+}
+
+func TestPDFTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"Literal", `<< /Title (Hello World) >>`, "Hello World"},
+		{"Escapes", `/Title (Line\\one \(nested (ok)\) \101)`, `Line\one (nested (ok)) A`},
+		{"Hex UTF-16", `/Title <FEFF00480069>`, "Hi"},
+		{"XMP", `<x:xmpmeta><dc:title><rdf:Alt><rdf:li xml:lang="x-default">XMP &amp; Title</rdf:li></rdf:Alt></dc:title></x:xmpmeta>`, "XMP & Title"},
+		{"None", `%PDF-1.7 no info`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pdfTitle([]byte(tt.data)); got != tt.want {
+				t.Errorf("pdfTitle() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenGraphResolver_OneSlotPerOrigin(t *testing.T) {
+	pdf := "%PDF-1.4\n" + strings.Repeat("x", fileHeadBytes+1024) +
+		"\ntrailer << /Info << /Title (Tail Title) >> >>\n%%EOF"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/streamed.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		if r.Header.Get("Range") != "" {
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(pdf))
+			return
+		}
+		// No Content-Length, so the size takes a ranged request too
+		w.(http.Flusher).Flush()
+		if _, err := io.WriteString(w, pdf); err != nil {
+			return
+		}
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"type":"video","version":"1.0","title":"Embedded","provider_name":"TestTube"}`)
+	})
+	mux.HandleFunc("/post", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Post</title>
+			<link rel="alternate" type="application/json+oembed" href="/oembed?format=json"></head></html>`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// One request in flight per origin: follow-up requests must not wait on the first response
+	policy := transport.LocalPolicy()
+	policy.Politeness = transport.NewPoliteness(1, 0, 0)
+	oembed := NewOEmbedResolver()
+	oembed.SetEgressPolicy(policy)
+	r := NewOpenGraphResolver()
+	r.SetEgressPolicy(policy)
+	r.SetOEmbed(oembed)
+
+	for path, want := range map[string]string{"/streamed.pdf": "Tail Title", "/post": "Embedded"} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		u, _ := url.Parse(ts.URL + path)
+		res, err := r.Resolve(ctx, u)
+		cancel()
+		if err != nil || res.Title != want {
+			t.Errorf("Resolve(%s) = %+v, %v; want title %q", path, res, err, want)
+		}
+		if path == "/streamed.pdf" && (res == nil || res.ContentLength != int64(len(pdf))) {
+			t.Errorf("Expected the size from the ranged request, got %+v", res)
+		}
+	}
+}
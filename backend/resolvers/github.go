@@ -8,6 +8,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
 )
 
 type GitHubResolver struct {
@@ -17,16 +19,25 @@ type GitHubResolver struct {
 }
 
 type githubRepoResponse struct {
-	FullName        string `json:"full_name"`
-	Description     string `json:"description"`
-	StargazersCount int    `json:"stargazers_count"`
-	Language        string `json:"language"`
-	ForksCount      int    `json:"forks_count"`
+	FullName        string      `json:"full_name"`
+	Description     string      `json:"description"`
+	StargazersCount int         `json:"stargazers_count"`
+	Language        string      `json:"language"`
+	ForksCount      int         `json:"forks_count"`
+	HTMLURL         string      `json:"html_url"`
+	CreatedAt       string      `json:"created_at"`
+	PushedAt        string      `json:"pushed_at"`
+	Owner           githubOwner `json:"owner"`
+}
+
+type githubOwner struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatar_url"`
 }
 
 func NewGitHubResolver(token string) *GitHubResolver {
 	return &GitHubResolver{
-		client:  SafeHttpClient(2 * time.Second),
+		client:  SafeHttpClient(2*time.Second, transport.DefaultPolicy()),
 		token:   token,
 		baseURL: "https://api.github.com",
 	}
@@ -36,6 +47,12 @@ func (r *GitHubResolver) Name() string {
 	return "github"
 }
 
+// SetEgressPolicy replaces the client's egress policy. The API is bounded by
+// our own rate limit, not by politeness towards a scraped site.
+func (r *GitHubResolver) SetEgressPolicy(p *transport.Policy) {
+	r.client = SafeHttpClient(r.client.Timeout, p.WithoutPoliteness())
+}
+
 func (r *GitHubResolver) CanHandle(u *url.URL) bool {
 	host := strings.ToLower(u.Host)
 	if host != "github.com" && host != "www.github.com" {
@@ -61,23 +78,26 @@ func (r *GitHubResolver) CanHandle(u *url.URL) bool {
 	return !reserved[parts[0]]
 }
 
+// Canonicalize reduces repository links to https://github.com/owner/repo;
+// GitHub treats owner and repository names case-insensitively
+func (r *GitHubResolver) Canonicalize(u *url.URL) (*url.URL, bool) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	owner := strings.ToLower(parts[0])
+	repo := strings.TrimSuffix(strings.ToLower(parts[1]), ".git")
+	return &url.URL{Scheme: "https", Host: "github.com", Path: "/" + owner + "/" + repo}, true
+}
+
 func (r *GitHubResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
 	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
 	owner := parts[0]
 	repo := parts[1]
 
 	apiURL := fmt.Sprintf("%s/repos/%s/%s", r.baseURL, owner, repo)
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	req, err := r.newRequest(ctx, apiURL)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
-	if r.token != "" {
-		req.Header.Set("Authorization", "token "+r.token)
-	}
-
 	resp, err := r.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -102,9 +122,43 @@ func (r *GitHubResolver) Resolve(ctx context.Context, u *url.URL) (*Result, erro
 		stats = fmt.Sprintf("%s | %s", stats, data.Language)
 	}
 
-	return &Result{
-		Title:       data.FullName,
-		Description: fmt.Sprintf("%s (%s)", data.Description, stats),
-		Platform:    "github",
-	}, nil
+	res := &Result{
+		Title:        data.FullName,
+		Description:  fmt.Sprintf("%s (%s)", data.Description, stats),
+		Platform:     "github",
+		SiteName:     "GitHub",
+		Author:       data.Owner.Login,
+		PublishedAt:  parseTime(data.CreatedAt),
+		UpdatedAt:    parseTime(data.PushedAt),
+		CanonicalURL: data.HTMLURL,
+	}
+	if data.Owner.AvatarURL != "" {
+		res.Image = &Image{URL: data.Owner.AvatarURL}
+	}
+	res.Validators = validatorsFrom(r.Name(), apiURL, resp.Header)
+	return res, nil
+}
+
+// Revalidate asks the API whether the repository changed. GitHub doesn't
+// count 304 responses against the rate limit, so this is nearly free.
+func (r *GitHubResolver) Revalidate(ctx context.Context, v *Validators) (bool, error) {
+	req, err := r.newRequest(ctx, v.URL)
+	if err != nil {
+		return false, err
+	}
+	return conditionalGet(r.client, req, v)
+}
+
+func (r *GitHubResolver) newRequest(ctx context.Context, apiURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
+	if r.token != "" {
+		req.Header.Set("Authorization", "token "+r.token)
+	}
+	return req, nil
 }
@@ -13,9 +13,6 @@ import (
 )
 
 func TestGitHubResolver(t *testing.T) {
-	transport.AllowLocalIPs = true
-	defer func() { transport.AllowLocalIPs = false }()
-
 	mux := http.NewServeMux()
 	mux.HandleFunc("/repos/owner/repo", func(w http.ResponseWriter, r *http.Request) {
 		data := githubRepoResponse{
@@ -23,6 +20,9 @@ func TestGitHubResolver(t *testing.T) {
 			Description:     "A great repository",
 			StargazersCount: 100,
 			Language:        "Go",
+			HTMLURL:         "https://github.com/owner/repo",
+			CreatedAt:       "2020-01-02T03:04:05Z",
+			Owner:           githubOwner{Login: "owner", AvatarURL: "https://avatars.example/u/1"},
 		}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(data); err != nil {
@@ -34,6 +34,7 @@ func TestGitHubResolver(t *testing.T) {
 	defer ts.Close()
 
 	resolver := NewGitHubResolver("")
+	resolver.SetEgressPolicy(transport.LocalPolicy())
 	ctx := context.Background()
 
 	t.Run("CanHandle", func(t *testing.T) {
@@ -73,5 +74,86 @@ func TestGitHubResolver(t *testing.T) {
 		if !strings.Contains(res.Description, "Go") {
 			t.Errorf("Expected description to contain Go, got %s", res.Description)
 		}
+		if res.Author != "owner" || res.SiteName != "GitHub" {
+			t.Errorf("Expected author owner on GitHub, got %q on %q", res.Author, res.SiteName)
+		}
+		if res.CanonicalURL != "https://github.com/owner/repo" {
+			t.Errorf("Expected canonical URL, got %s", res.CanonicalURL)
+		}
+		if res.Image == nil || res.Image.URL != "https://avatars.example/u/1" {
+			t.Errorf("Expected owner avatar image, got %+v", res.Image)
+		}
+		if res.PublishedAt == nil || res.PublishedAt.Year() != 2020 {
+			t.Errorf("Expected published date in 2020, got %v", res.PublishedAt)
+		}
 	})
 }
+
+func TestGitHubResolver_Revalidate(t *testing.T) {
+	etag := `W/"abc"`
+	var full, conditional int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			t.Errorf("Expected the token on every request, got %q", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		json.NewEncoder(w).Encode(githubRepoResponse{FullName: "owner/repo", Owner: githubOwner{Login: "owner"}})
+	}))
+	defer ts.Close()
+
+	resolver := NewGitHubResolver("secret")
+	resolver.SetEgressPolicy(transport.LocalPolicy())
+	resolver.baseURL = ts.URL
+
+	u, _ := url.Parse("https://github.com/owner/repo")
+	res, err := resolver.Resolve(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := res.Validators
+	if v == nil || v.ETag != etag || v.Resolver != "github" || v.URL != ts.URL+"/repos/owner/repo" {
+		t.Fatalf("Expected the API ETag to be recorded, got %+v", v)
+	}
+
+	if notModified, err := resolver.Revalidate(context.Background(), v); err != nil || !notModified {
+		t.Errorf("Expected 304 Not Modified, got %v (%v)", notModified, err)
+	}
+	etag = `W/"def"`
+	if notModified, err := resolver.Revalidate(context.Background(), v); err != nil || notModified {
+		t.Errorf("Expected a changed repository to be reported, got %v (%v)", notModified, err)
+	}
+	if full != 2 || conditional != 1 {
+		t.Errorf("Expected 2 full and 1 conditional responses, got %d and %d", full, conditional)
+	}
+}
+
+func TestGitHubResolver_RetriesTransientFailures(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// GitHub's secondary rate limit
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(githubRepoResponse{FullName: "owner/repo", Owner: githubOwner{Login: "owner"}})
+	}))
+	defer ts.Close()
+
+	resolver := NewGitHubResolver("")
+	resolver.SetEgressPolicy(transport.LocalPolicy())
+	resolver.baseURL = ts.URL
+
+	u, _ := url.Parse("https://github.com/owner/repo")
+	res, err := resolver.Resolve(context.Background(), u)
+	if err != nil || res.Title != "owner/repo" || calls != 2 {
+		t.Errorf("Expected the rate-limited call to be retried, got %+v (%v) after %d calls", res, err, calls)
+	}
+}
@@ -0,0 +1,320 @@
+package resolvers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Warning codes attached by the DomainAnalyzer
+const (
+	WarnMixedScript      = "mixed-script"
+	WarnWholeScript      = "whole-script-confusable"
+	WarnLookalike        = "lookalike"
+	WarnTyposquat        = "typosquat"
+	WarnBrandInSubdomain = "brand-in-subdomain"
+)
+
+// minTyposquatLength keeps short brands (chase, apple) from matching ordinary words one edit away
+const minTyposquatLength = 6
+
+// defaultProtectedDomains are brands commonly imitated by phishing links
+var defaultProtectedDomains = []string{
+	"google.com", "youtube.com", "gmail.com", "facebook.com", "instagram.com", "whatsapp.com",
+	"apple.com", "icloud.com", "microsoft.com", "live.com", "outlook.com", "office.com",
+	"amazon.com", "paypal.com", "netflix.com", "github.com", "twitter.com", "linkedin.com",
+	"dropbox.com", "spotify.com", "reddit.com", "steamcommunity.com", "telegram.org",
+	"coinbase.com", "binance.com", "chase.com", "bankofamerica.com", "wellsfargo.com",
+}
+
+// defaultBrandDomains are other registrable domains the protected brands run
+// themselves, so their own country sites don't warn as lookalikes
+var defaultBrandDomains = []string{
+	"google.de", "google.co.uk", "google.fr", "google.es", "google.it", "google.ca", "google.com.au",
+	"google.co.jp", "google.co.in", "google.com.br", "google.nl", "google.pl", "google.ch",
+	"youtube.be", "facebook.net", "fb.com", "whatsapp.net", "apple.co", "microsoft.net",
+	"live.net", "office.net", "office365.com",
+	"amazon.de", "amazon.co.uk", "amazon.fr", "amazon.es", "amazon.it", "amazon.ca", "amazon.com.au",
+	"amazon.co.jp", "amazon.in", "amazon.com.br", "amazon.nl", "amazon.pl", "amazon.se",
+	"amazon.com.mx", "amazon.sg", "amazon.com.tr", "amazon.ae", "amazon.sa",
+	"paypal.me", "netflix.net", "steampowered.com", "telegram.me", "telegram.dog", "binance.us",
+}
+
+// confusables maps characters to the Latin prototype they are visually
+// confusable with. It is the subset of Unicode TR39 confusables.txt that
+// matters for hostnames; NFKC already folds fullwidth and compatibility forms.
+var confusables = map[rune]string{
+	// Cyrillic
+	'а': "a", 'е': "e", 'ё': "e", 'һ': "h", 'і': "i", 'ї': "i", 'ј': "j", 'к': "k", 'ӏ': "l",
+	'о': "o", 'р': "p", 'ԛ': "q", 'г': "r", 'ѕ': "s", 'ѵ': "v", 'ԝ': "w", 'х': "x", 'у': "y",
+	'ү': "y", 'ԁ': "d", 'ԍ': "g",
+	// Greek
+	'α': "a", 'ι': "i", 'κ': "k", 'ν': "v", 'ο': "o", 'ρ': "p", 'υ': "u", 'χ': "x", 'γ': "y",
+	// Armenian
+	'օ': "o", 'ս': "u", 'ց': "g", 'հ': "h", 'ո': "n", 'զ': "q",
+	// Latin lookalikes outside ASCII
+	'ı': "i", 'ɩ': "i", 'ɑ': "a", 'ɡ': "g", 'ɢ': "g", 'ʜ': "h", 'ɪ': "i", 'ʟ': "l", 'ɴ': "n",
+	'ʀ': "r", 'ʏ': "y", 'ᴅ': "d", 'ᴇ': "e", 'ᴋ': "k", 'ᴍ': "m", 'ᴏ': "o", 'ᴘ': "p", 'ᴛ': "t",
+	'ᴜ': "u", 'ᴠ': "v", 'ᴡ': "w", 'ᴢ': "z", 'ƅ': "b", 'ð': "d", 'ø': "o", 'ł': "l", 'đ': "d",
+	// ASCII digits read as letters
+	'0': "o", '1': "l", '3': "e", '5': "s",
+}
+
+// multiConfusables are ASCII sequences that render like a single letter
+var multiConfusables = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+
+// skeleton reduces a label to the form TR39 uses to compare for confusability:
+// two labels with the same skeleton look alike.
+func skeleton(label string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKC.String(strings.ToLower(label)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue // Combining marks hide under the base letter
+		}
+		if s, ok := confusables[r]; ok {
+			b.WriteString(s)
+			continue
+		}
+		// Strip accents: é -> e
+		if d := norm.NFD.String(string(r)); len(d) > 1 {
+			if base := []rune(d)[0]; base < unicode.MaxASCII {
+				b.WriteRune(base)
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return multiConfusables.Replace(b.String())
+}
+
+// scriptTables are the scripts told apart when looking for mixed-script labels
+var scriptTables = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin}, {"Cyrillic", unicode.Cyrillic}, {"Greek", unicode.Greek},
+	{"Armenian", unicode.Armenian}, {"Georgian", unicode.Georgian}, {"Hebrew", unicode.Hebrew},
+	{"Arabic", unicode.Arabic}, {"Han", unicode.Han}, {"Hiragana", unicode.Hiragana},
+	{"Katakana", unicode.Katakana}, {"Hangul", unicode.Hangul}, {"Bopomofo", unicode.Bopomofo},
+	{"Thai", unicode.Thai}, {"Devanagari", unicode.Devanagari}, {"Cherokee", unicode.Cherokee},
+}
+
+// allowedMixes are the combinations TR39 "Highly Restrictive" accepts in one label
+var allowedMixes = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// scriptsOf returns the distinct scripts used by a label, ignoring Common
+// characters (digits, hyphen, the Japanese prolonged sound mark) and combining marks
+func scriptsOf(label string) []string {
+	var scripts []string
+	seen := make(map[string]bool)
+	for _, r := range label {
+		if !unicode.IsLetter(r) || unicode.Is(unicode.Common, r) {
+			continue
+		}
+		name := "Other"
+		for _, s := range scriptTables {
+			if unicode.Is(s.table, r) {
+				name = s.name
+				break
+			}
+		}
+		if !seen[name] {
+			seen[name] = true
+			scripts = append(scripts, name)
+		}
+	}
+	return scripts
+}
+
+// isAllowedMix reports whether the scripts fit within one TR39 allowed combination
+func isAllowedMix(scripts []string) bool {
+	if len(scripts) <= 1 {
+		return true
+	}
+	for _, mix := range allowedMixes {
+		ok := true
+		for _, s := range scripts {
+			found := false
+			for _, m := range mix {
+				if s == m {
+					found = true
+					break
+				}
+			}
+			if !found {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+type protectedDomain struct {
+	domain   string // Registrable domain, e.g. paypal.com
+	label    string // Domain without its public suffix, e.g. paypal
+	skeleton string
+}
+
+// DomainAnalyzer flags destinations whose hostname imitates another domain:
+// IDN labels mixing scripts, labels written entirely in confusable characters,
+// and lookalikes or typos of a list of protected domains.
+type DomainAnalyzer struct {
+	protected []protectedDomain
+	owned     map[string]bool // Registrable domains of the protected brands
+}
+
+// NewDomainAnalyzer returns an analyzer protecting the built-in brand list
+func NewDomainAnalyzer() *DomainAnalyzer {
+	a := &DomainAnalyzer{owned: make(map[string]bool)}
+	a.AddProtectedDomains(defaultProtectedDomains...)
+	a.AddProtectedDomains(defaultBrandDomains...)
+	return a
+}
+
+// AddProtectedDomains extends the list of domains lookalikes are checked
+// against. A domain whose name is already protected (amazon.de after
+// amazon.com) is recorded as another domain of that brand.
+func (a *DomainAnalyzer) AddProtectedDomains(domains ...string) {
+	if a.owned == nil {
+		a.owned = make(map[string]bool)
+	}
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "www.")
+		if d == "" {
+			continue
+		}
+		d = registrableDomain(d)
+		a.owned[d] = true
+		label := domainLabel(d)
+		if a.isProtectedLabel(label) {
+			continue
+		}
+		a.protected = append(a.protected, protectedDomain{domain: d, label: label, skeleton: skeleton(label)})
+	}
+}
+
+func (a *DomainAnalyzer) isProtectedLabel(label string) bool {
+	for _, p := range a.protected {
+		if p.label == label {
+			return true
+		}
+	}
+	return false
+}
+
+// domainLabel strips the public suffix from a registrable domain
+func domainLabel(domain string) string {
+	if i := strings.Index(domain, "."); i >= 0 {
+		return domain[:i]
+	}
+	return domain
+}
+
+// Analyze returns warnings for a hostname; an empty slice means nothing looked deceptive
+func (a *DomainAnalyzer) Analyze(host string) []Warning {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || net.ParseIP(host) != nil {
+		return nil
+	}
+	display, err := idna.ToUnicode(host)
+	if err != nil {
+		display = host
+	}
+
+	var warnings []Warning
+	add := func(code, target, format string, args ...any) {
+		warnings = append(warnings, Warning{Code: code, Host: display, Target: target, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, label := range strings.Split(display, ".") {
+		scripts := scriptsOf(label)
+		if !isAllowedMix(scripts) {
+			add(WarnMixedScript, "", "Label %q mixes %s characters", label, strings.Join(scripts, ", "))
+			continue
+		}
+		// A single non-Latin script spelling out an ASCII-looking word (аррӏе)
+		if len(scripts) == 1 && scripts[0] != "Latin" && isASCII(skeleton(label)) {
+			add(WarnWholeScript, "", "Label %q is written in %s characters that look like Latin %q", label, scripts[0], skeleton(label))
+		}
+	}
+
+	domain := registrableDomain(display)
+	label := domainLabel(domain)
+	if a.owned[domain] {
+		return warnings // The brand itself, on any of its subdomains
+	}
+
+	sk := skeleton(label)
+	for _, p := range a.protected {
+		switch {
+		case label == p.label:
+			// paypal.xyz: the brand's own name on a domain it doesn't hold; the
+			// ones it does hold are in owned
+			add(WarnLookalike, p.domain, "%s uses the name of %s on a different domain", domain, p.domain)
+		case sk == p.skeleton:
+			add(WarnLookalike, p.domain, "%s looks like %s", domain, p.domain)
+		case len(p.label) >= minTyposquatLength && editDistance(sk, p.skeleton) == 1:
+			add(WarnTyposquat, p.domain, "%s is one character away from %s", domain, p.domain)
+		}
+	}
+
+	// paypal.com.account-verify.net, secure-paypal.com.evil.net: the brand sits
+	// in front of someone else's domain
+	if sub := strings.TrimSuffix(display, domain); sub != "" {
+		sub = "." + sub
+		for _, p := range a.protected {
+			if strings.Contains(sub, "."+p.domain+".") || strings.Contains(sub, "-"+p.domain+".") {
+				add(WarnBrandInSubdomain, p.domain, "%s is a subdomain of %s, not %s", display, domain, p.domain)
+			}
+		}
+	}
+	return warnings
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= unicode.MaxASCII {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// editDistance is the optimal string alignment distance (Levenshtein plus
+// adjacent transpositions), so "gogole" is one edit from "google"
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
@@ -0,0 +1,106 @@
+package resolvers
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestDomainAnalyzer(t *testing.T) {
+	a := NewDomainAnalyzer()
+	a.AddProtectedDomains("example-bank.com", "amazon.co.uk")
+
+	tests := []struct {
+		host   string
+		code   string // Expected warning code; empty means no warning
+		target string
+	}{
+		{"www.paypal.com", "", ""},
+		{"login.paypal.com", "", ""},
+		{"www.amazon.co.uk", "", ""},
+		// Country sites and other domains the brands run themselves
+		{"amazon.de", "", ""},
+		{"google.de", "", ""},
+		{"telegram.me", "", ""},
+		{"static.facebook.net", "", ""},
+		{"news.ycombinator.com", "", ""},
+		{"bücher.de", "", ""},
+		{"xn--bcher-kva.de", "", ""},
+		{"127.0.0.1", "", ""},
+		{"paypa1.com", WarnLookalike, "paypal.com"},
+		{"rnicrosoft.com", WarnLookalike, "microsoft.com"},
+		{"g00gle.net", WarnLookalike, "google.com"},
+		{"examp1e-bank.com", WarnLookalike, "example-bank.com"},
+		{"gogole.com", WarnTyposquat, "google.com"},
+		{"amazom.com", WarnTyposquat, "amazon.com"},
+		// Cyrillic а in an otherwise Latin label
+		{"pаypal.com", WarnMixedScript, ""},
+		{"xn--pypal-4ve.com", WarnMixedScript, ""},
+		// Entirely Cyrillic: аррӏе
+		{"xn--80ak6aa92e.com", WarnWholeScript, ""},
+		// The brand's name on a TLD it doesn't hold
+		{"paypal.xyz", WarnLookalike, "paypal.com"},
+		{"paypal.co", WarnLookalike, "paypal.com"},
+		{"amazon.xyz", WarnLookalike, "amazon.com"},
+		{"telegram.click", WarnLookalike, "telegram.org"},
+		{"paypal.com.account-verify.net", WarnBrandInSubdomain, "paypal.com"},
+		{"paypal.com.evil.net", WarnBrandInSubdomain, "paypal.com"},
+		{"secure-paypal.com.evil.net", WarnBrandInSubdomain, "paypal.com"},
+		{"paypal.com.paypal.xyz", WarnBrandInSubdomain, "paypal.com"},
+		// Japanese mixing Han and Katakana is fine
+		{"東京タワー.jp", "", ""},
+	}
+
+	for _, tt := range tests {
+		warnings := a.Analyze(tt.host)
+		if tt.code == "" {
+			if len(warnings) != 0 {
+				t.Errorf("%s: expected no warnings, got %+v", tt.host, warnings)
+			}
+			continue
+		}
+		found := false
+		for _, w := range warnings {
+			if w.Code == tt.code && w.Target == tt.target {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected %s warning (target %q), got %+v", tt.host, tt.code, tt.target, warnings)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"google", "google", 0},
+		{"google", "gogole", 1},
+		{"google", "googel", 1},
+		{"google", "goggle", 1},
+		{"google", "gooogle", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestResolverManager_Warnings(t *testing.T) {
+	manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+	manager.Register(&MockFallback{MockResolver{name: "generic", canHandle: true}})
+	manager.SetExpander(&MockExpander{target: "https://paypa1.com/login"})
+
+	res := manager.ResolveMulti(context.Background(), []string{"https://example.com/offer"})["https://example.com/offer"]
+	if res == nil || len(res.Warnings) == 0 || res.Warnings[0].Code != WarnLookalike {
+		t.Fatalf("Expected lookalike warning on the final destination, got %+v", res)
+	}
+
+	u, _ := url.Parse("https://example.com/")
+	if w := NewDomainAnalyzer().Analyze(u.Hostname()); len(w) != 0 {
+		t.Errorf("Expected no warnings for example.com, got %+v", w)
+	}
+}
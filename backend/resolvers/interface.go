@@ -2,21 +2,146 @@ package resolvers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/url"
+	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
 )
 
-// Result represents the outcome of a URL resolution
+// Image describes a preview image (thumbnail, avatar, og:image) for a result
+type Image struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// Result represents the outcome of a URL resolution.
+// Everything beyond Title and Platform is optional and filled in by resolvers
+// when the source provides it.
 type Result struct {
 	Title       string `json:"title"`
 	Description string `json:"description,omitempty"`
 	Platform    string `json:"platform"`
+
+	Image       *Image     `json:"image,omitempty"`
+	SiteName    string     `json:"siteName,omitempty"`
+	Author      string     `json:"author,omitempty"`
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+
+	// CanonicalURL is the URL the source declares as authoritative (rel=canonical, og:url, API html_url)
+	CanonicalURL string `json:"canonicalUrl,omitempty"`
+	// FinalURL is the URL that was actually fetched after following redirects
+	FinalURL string `json:"finalUrl,omitempty"`
+	// CleanURL is the requested URL without tracking parameters, in canonical form; it is also the cache key
+	CleanURL    string `json:"cleanUrl,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Language    string `json:"language,omitempty"`
+
+	// FileName and ContentLength describe non-HTML destinations (downloads, PDFs, media)
+	FileName      string `json:"fileName,omitempty"`
+	ContentLength int64  `json:"contentLength,omitempty"`
+
+	// Risk categorizes dangerous destinations (executable, macro-document, script-uri...) for prominent display
+	Risk string `json:"risk,omitempty"`
+
+	// RedirectChain lists every URL visited on the way to FinalURL, starting with the original
+	RedirectChain []Hop `json:"redirectChain,omitempty"`
+
+	// Reputation is the worst threat-feed verdict over the destination and every hop
+	Reputation string `json:"reputation,omitempty"`
+
+	// Security describes the HTTPS posture of the destination, when it was fetched
+	Security *Security `json:"security,omitempty"`
+
+	// Warnings flag a destination that imitates another domain (homographs, lookalikes)
+	Warnings []Warning `json:"warnings,omitempty"`
+
+	// FetchedAt is when the result was resolved or last revalidated with the origin
+	FetchedAt *time.Time `json:"fetchedAt,omitempty"`
+	// Validators let a stale cache entry be refreshed with a conditional request
+	Validators *Validators `json:"validators,omitempty"`
+}
+
+// Validators are the origin's cache validators for the response a result was built from
+type Validators struct {
+	// Resolver is the name of the resolver that can revalidate the result
+	Resolver string `json:"resolver"`
+	// URL is the page or API endpoint the validators belong to
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// Security is the HTTPS posture of a destination
+type Security struct {
+	HTTPS      bool   `json:"https"`
+	TLSVersion string `json:"tlsVersion,omitempty"`
+	// Certificate is the leaf certificate the destination presented
+	Certificate *Certificate `json:"certificate,omitempty"`
+	// Downgrade is set when any hop of the redirect chain went from https to http
+	Downgrade bool `json:"downgrade,omitempty"`
+}
+
+// Certificate summarizes a TLS leaf certificate
+type Certificate struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dnsNames,omitempty"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	// NewlyIssued is set for certificates younger than a week, common on freshly set-up phishing sites
+	NewlyIssued bool `json:"newlyIssued,omitempty"`
+}
+
+// Warning describes why a destination looks deceptive
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Host is the offending hostname in Unicode form, as the user would see it
+	Host string `json:"host"`
+	// Target is the protected domain being imitated, when there is one
+	Target string `json:"target,omitempty"`
+}
+
+// Hop is one step of a redirect chain
+type Hop struct {
+	URL string `json:"url"`
+	// Method is the request method that produced Status (HEAD, or GET when HEAD was refused)
+	Method string `json:"method,omitempty"`
+	// Status is the HTTP status the hop answered with; 0 means it wasn't fetched,
+	// either because it was unwrapped offline or a specialized resolver handles it directly
+	Status int `json:"status"`
+	// CrossDomain is set when the hop left the previous hop's registrable domain
+	CrossDomain bool `json:"crossDomain,omitempty"`
+	// Downgrade is set when the hop went from https to http
+	Downgrade bool `json:"downgrade,omitempty"`
+	// Unwrapped is set on link-wrapper hops (google.com/url, safelinks...) decoded offline
+	Unwrapped bool `json:"unwrapped,omitempty"`
+	// Reputation is the threat-feed verdict for this URL (clean, suspicious, malicious)
+	Reputation string `json:"reputation,omitempty"`
 }
 
 // Cache defines the interface for storing and retrieving results
 type Cache interface {
-	Get(key string) (string, bool)
-	Set(key string, title string)
-	GetMulti(keys []string) map[string]string
+	Get(key string) (*Result, bool)
+	Set(key string, res *Result)
+	GetMulti(keys []string) map[string]*Result
+}
+
+// HashPrefixCache is implemented by caches that can be searched by the
+// SHA-256 of their keys (see HashKey), so clients can look up a URL without
+// revealing it. Results are keyed by full hex hash; at most limit are returned.
+type HashPrefixCache interface {
+	GetByHashPrefix(prefix string, limit int) map[string]*Result
+}
+
+// HashKey is the lower-case hex SHA-256 of a cache key (the canonical URL)
+func HashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
 }
 
 // Resolver defines the interface for platform-specific URL resolution
@@ -30,3 +155,35 @@ type Resolver interface {
 	// Resolve returns a human-friendly title/description for the URL
 	Resolve(ctx context.Context, u *url.URL) (*Result, error)
 }
+
+// EgressConfigurable is implemented by resolvers that make their own HTTP
+// requests, so the manager can hand them its egress policy
+type EgressConfigurable interface {
+	SetEgressPolicy(p *transport.Policy)
+}
+
+// Revalidator is implemented by resolvers that can check a stale result
+// with a conditional request instead of resolving it again. Revalidate
+// reports whether the origin answered 304 Not Modified.
+type Revalidator interface {
+	Revalidate(ctx context.Context, v *Validators) (bool, error)
+}
+
+// FallbackResolver is implemented by catch-all resolvers. IsFallbackFor reports
+// whether the resolver only handles u generically (e.g. by scraping the page),
+// in which case the manager follows redirects first so specialized resolvers
+// get a chance at the final destination.
+type FallbackResolver interface {
+	IsFallbackFor(u *url.URL) bool
+}
+
+// Expander follows redirects as a pipeline stage before resolvers run
+type Expander interface {
+	// Expand returns the final URL after following redirects from u, along
+	// with the chain of hops that led there
+	Expand(ctx context.Context, u *url.URL) (*url.URL, []Hop, error)
+
+	// IsShortener reports whether u is a known shortener, which is always
+	// expanded and whose expansion failures are fatal
+	IsShortener(u *url.URL) bool
+}
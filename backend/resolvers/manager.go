@@ -7,17 +7,28 @@ import (
 	"net/url"
 	"sync"
 	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/reputation"
+	"github.com/sph/youtube-url-replacer/backend/transport"
 )
 
 type ResolverManager struct {
-	resolvers []Resolver
-	cache     Cache
-	timeout   time.Duration
+	resolvers  []Resolver
+	unwrapper  *Unwrapper
+	expander   Expander
+	analyzer   *DomainAnalyzer
+	reputation *reputation.DB
+	egress     *transport.Policy
+	cache      Cache
+	timeout    time.Duration
+	maxAge     time.Duration
 }
 
 func NewResolverManager(cache Cache) *ResolverManager {
 	return &ResolverManager{
 		resolvers: []Resolver{},
+		unwrapper: NewUnwrapper(),
+		analyzer:  NewDomainAnalyzer(),
 		cache:     cache,
 		timeout:   2 * time.Second, // Default timeout
 	}
@@ -27,10 +38,170 @@ func (m *ResolverManager) SetTimeout(t time.Duration) {
 	m.timeout = t
 }
 
+// SetMaxAge makes cached results older than d stale. Stale results are
+// revalidated with the origin when they carry validators and resolved again
+// otherwise; zero keeps cached results forever.
+func (m *ResolverManager) SetMaxAge(d time.Duration) {
+	m.maxAge = d
+}
+
 func (m *ResolverManager) Register(r Resolver) {
+	if c, ok := r.(EgressConfigurable); ok && m.egress != nil {
+		c.SetEgressPolicy(m.egress)
+	}
 	m.resolvers = append(m.resolvers, r)
 }
 
+// SetEgressPolicy applies one egress policy to every resolver and the expander
+// that make their own requests, including ones registered later
+func (m *ResolverManager) SetEgressPolicy(p *transport.Policy) {
+	m.egress = p
+	for _, r := range m.resolvers {
+		if c, ok := r.(EgressConfigurable); ok {
+			c.SetEgressPolicy(p)
+		}
+	}
+	if c, ok := m.expander.(EgressConfigurable); ok {
+		c.SetEgressPolicy(p)
+	}
+}
+
+// SetUnwrapper replaces the offline link-wrapper stage; nil disables it
+func (m *ResolverManager) SetUnwrapper(w *Unwrapper) {
+	m.unwrapper = w
+}
+
+// SetExpander installs the redirect-following stage that runs before resolvers
+func (m *ResolverManager) SetExpander(e Expander) {
+	if c, ok := e.(EgressConfigurable); ok && m.egress != nil {
+		c.SetEgressPolicy(m.egress)
+	}
+	m.expander = e
+}
+
+// SetAnalyzer replaces the deceptive-domain check run on final destinations; nil disables it
+func (m *ResolverManager) SetAnalyzer(a *DomainAnalyzer) {
+	m.analyzer = a
+}
+
+// SetReputation installs the threat-feed database. Every URL in a chain is
+// tagged with its verdict and known-malicious hosts are never fetched.
+func (m *ResolverManager) SetReputation(db *reputation.DB) {
+	m.reputation = db
+}
+
+// isBlocked reports whether u must not be fetched
+func (m *ResolverManager) isBlocked(u *url.URL) bool {
+	return m.reputation != nil && m.reputation.IsMalicious(u)
+}
+
+// fetchGuard rejects requests to known-malicious hosts
+func (m *ResolverManager) fetchGuard(u *url.URL) error {
+	if m.isBlocked(u) {
+		return fmt.Errorf("%w: %s", ErrBlockedHost, u.Hostname())
+	}
+	return nil
+}
+
+// tagReputation records the verdict of every hop and of the destination,
+// and returns the worst of them
+func (m *ResolverManager) tagReputation(chain []Hop, final *url.URL) string {
+	if m.reputation == nil {
+		return ""
+	}
+	verdict := m.reputation.Check(final)
+	for i := range chain {
+		if u, err := url.Parse(chain[i].URL); err == nil {
+			v := m.reputation.Check(u)
+			chain[i].Reputation = string(v)
+			verdict = reputation.Worse(verdict, v)
+		}
+	}
+	return string(verdict)
+}
+
+// blockedResult describes a destination that was not fetched because it is known-malicious
+func blockedResult(u *url.URL) *Result {
+	return &Result{
+		Title:       u.Hostname(),
+		Description: "Known malicious site; not fetched",
+		Platform:    "Blocked",
+	}
+}
+
+// recheck tags a cached result against the feeds as they are now, since a
+// destination may have been listed after it was cached. Listed destinations
+// come back blocked; nil means the result was blocked but is no longer, and
+// the URL has to be resolved again.
+func (m *ResolverManager) recheck(res *Result, raw string) *Result {
+	if m.reputation == nil {
+		return res
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return res
+	}
+	final := finalURL(res, u)
+	if res.Platform == "Blocked" && !m.isBlocked(final) {
+		return nil
+	}
+
+	// The cached result is shared, so the tags go on copies
+	out := *res
+	out.RedirectChain = append([]Hop(nil), res.RedirectChain...)
+	out.Reputation = m.tagReputation(out.RedirectChain, final)
+	if out.Platform != "Blocked" && m.isBlocked(final) {
+		blocked := blockedResult(final)
+		blocked.FinalURL = out.FinalURL
+		blocked.RedirectChain = out.RedirectChain
+		blocked.CleanURL = out.CleanURL
+		blocked.Reputation = out.Reputation
+		blocked.Warnings = out.Warnings
+		return blocked
+	}
+	return &out
+}
+
+// hasSpecialist reports whether a non-fallback resolver claims the URL
+func (m *ResolverManager) hasSpecialist(u *url.URL) bool {
+	for _, r := range m.resolvers {
+		if !r.CanHandle(u) {
+			continue
+		}
+		if f, ok := r.(FallbackResolver); ok && f.IsFallbackFor(u) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// expand runs the redirect stage. Known shorteners are always expanded; other
+// URLs only when no specialized resolver claims them, and failures there are
+// not fatal since the resolvers can still try the original URL.
+func (m *ResolverManager) expand(ctx context.Context, u *url.URL) (*url.URL, []Hop, error) {
+	if m.expander == nil {
+		return u, nil, nil
+	}
+	shortener := m.expander.IsShortener(u)
+	if !shortener && m.hasSpecialist(u) {
+		return u, nil, nil
+	}
+
+	if named, ok := m.expander.(interface{ Name() string }); ok {
+		ctx = transport.WithAuditResolver(ctx, named.Name())
+	}
+	final, chain, err := m.expander.Expand(ctx, u)
+	if err != nil {
+		if shortener {
+			return nil, nil, err
+		}
+		log.Printf("Redirect expansion failed for %s: %v", u.String(), err)
+		return u, nil, nil
+	}
+	return final, chain, nil
+}
+
 // resolveRecursively attempts to resolve a URL, skipping the caller to avoid infinite loops
 func (m *ResolverManager) resolveRecursively(ctx context.Context, u *url.URL, skipResolver string) (*Result, error) {
 	for _, r := range m.resolvers {
@@ -38,7 +209,7 @@ func (m *ResolverManager) resolveRecursively(ctx context.Context, u *url.URL, sk
 			continue
 		}
 		if r.CanHandle(u) {
-			res, err := r.Resolve(ctx, u)
+			res, err := r.Resolve(transport.WithAuditResolver(ctx, r.Name()), u)
 			if err != nil {
 				continue
 			}
@@ -50,6 +221,62 @@ func (m *ResolverManager) resolveRecursively(ctx context.Context, u *url.URL, sk
 	return nil, fmt.Errorf("no resolver found for %s", u.String())
 }
 
+// finalURL is where the user ends up: the resolver's FinalURL when it
+// followed redirects of its own (meta refresh, oEmbed), otherwise the expanded URL
+func finalURL(res *Result, u *url.URL) *url.URL {
+	if res.FinalURL != "" {
+		if f, err := url.Parse(res.FinalURL); err == nil && f.Host != "" {
+			return f
+		}
+	}
+	return u
+}
+
+// joinChains appends the expansion chain to the unwrapped hops, re-deriving the
+// flags of the first expanded hop against the last wrapper
+func joinChains(unwrapped, expanded []Hop, final *url.URL) []Hop {
+	if len(unwrapped) == 0 {
+		return expanded
+	}
+	last := &unwrapped[len(unwrapped)-1]
+	if len(expanded) == 0 {
+		return append(unwrapped, newHop(final, 0, last))
+	}
+	if first, err := url.Parse(expanded[0].URL); err == nil {
+		hop := newHop(first, expanded[0].Status, last)
+		hop.Method = expanded[0].Method
+		expanded[0] = hop
+	}
+	return append(unwrapped, expanded...)
+}
+
+// extendChain appends the hops a resolver followed on its own (meta refresh,
+// script redirects) to the chain. The resolver's first hop is dropped when the
+// chain already ends there, and the flags of the first appended hop are
+// re-derived against the chain's last.
+func extendChain(chain, followed []Hop) []Hop {
+	if len(chain) > 0 && len(followed) > 0 && chain[len(chain)-1].URL == followed[0].URL {
+		followed = followed[1:]
+	}
+	if len(followed) == 0 {
+		return chain
+	}
+	if len(chain) == 0 {
+		return followed
+	}
+	if first, err := url.Parse(followed[0].URL); err == nil {
+		hop := newHop(first, followed[0].Status, &chain[len(chain)-1])
+		hop.Method = followed[0].Method
+		hop.Unwrapped = followed[0].Unwrapped
+		followed[0] = hop
+	}
+	return append(chain[:len(chain):len(chain)], followed...)
+}
+
+// ResolveMulti resolves a batch of URLs. Each URL is reduced to its canonical
+// form first, so tracking-parameter variants and alternate forms of the same
+// link share one cache entry and one resolution. That resolution fetches the
+// first variant as supplied, since sites don't always serve the cleaned form.
 func (m *ResolverManager) ResolveMulti(ctx context.Context, urls []string) map[string]*Result {
 	// Apply global timeout if not already set on context
 	if m.timeout > 0 {
@@ -58,21 +285,50 @@ func (m *ResolverManager) ResolveMulti(ctx context.Context, urls []string) map[s
 		defer cancel()
 	}
 
+	if m.reputation != nil {
+		ctx = withFetchGuard(ctx, m.fetchGuard)
+	}
+
 	results := make(map[string]*Result)
-	var missingURLs []string
 
-	// 1. Check Cache (Note: current cache only stores title strings, 
-	// we may want to upgrade this later to store JSON of Result)
-	cached := m.cache.GetMulti(urls)
-	for _, u := range urls {
-		if val, ok := cached[u]; ok {
-			results[u] = &Result{Title: val}
-		} else {
-			missingURLs = append(missingURLs, u)
+	// Group the raw URLs by cache key
+	rawByKey := make(map[string][]string)
+	var keys []string
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			log.Printf("Failed to parse URL %s: %v", raw, err)
+			continue
+		}
+		key := m.canonicalize(u).String()
+		if _, ok := rawByKey[key]; !ok {
+			keys = append(keys, key)
 		}
+		rawByKey[key] = append(rawByKey[key], raw)
 	}
 
-	if len(missingURLs) == 0 {
+	// 1. Check Cache
+	var missingKeys []string
+	stale := make(map[string]*Result)
+	cached := m.cache.GetMulti(keys)
+	for _, key := range keys {
+		val, ok := cached[key]
+		if ok && !m.isStale(val) {
+			if val = m.recheck(val, rawByKey[key][0]); val != nil {
+				for _, raw := range rawByKey[key] {
+					results[raw] = val
+				}
+				continue
+			}
+			ok = false // Blocked when cached, but delisted since
+		}
+		if ok {
+			stale[key] = val
+		}
+		missingKeys = append(missingKeys, key)
+	}
+
+	if len(missingKeys) == 0 {
 		return results
 	}
 
@@ -80,41 +336,176 @@ func (m *ResolverManager) ResolveMulti(ctx context.Context, urls []string) map[s
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 
-	for _, rawURL := range missingURLs {
+	for _, missing := range missingKeys {
 		wg.Add(1)
-		go func(raw string) {
+		go func(key string) {
 			defer wg.Done()
 
-			u, err := url.Parse(raw)
-			if err != nil {
-				log.Printf("Failed to parse URL %s: %v", raw, err)
-				return
+			old := stale[key]
+			var res *Result
+			if old != nil {
+				if res = m.revalidate(ctx, old); res != nil {
+					res = m.recheck(res, rawByKey[key][0])
+				}
 			}
-
-			for _, r := range m.resolvers {
-				if r.CanHandle(u) {
-					res, err := r.Resolve(ctx, u)
-					if err != nil {
-						log.Printf("Resolver %s failed for %s: %v", r.Name(), raw, err)
-						continue // Try next resolver if possible
-					}
-
-					if res != nil && res.Title != "" {
-						mu.Lock()
-						results[raw] = res
-						m.cache.Set(raw, res.Title)
-						mu.Unlock()
-						return
-					}
+			if res == nil {
+				if res = m.resolveKey(ctx, key, rawByKey[key][0]); res != nil {
+					now := time.Now()
+					res.FetchedAt = &now
+				}
+			}
+			store := res != nil
+			if res == nil {
+				// A stale answer beats none when the origin can't be reached
+				if res = m.recheck(old, rawByKey[key][0]); res == nil {
+					return
 				}
 			}
-		}(rawURL)
+
+			mu.Lock()
+			for _, raw := range rawByKey[key] {
+				results[raw] = res
+			}
+			if store {
+				m.cache.Set(key, res)
+			}
+			mu.Unlock()
+		}(missing)
 	}
 
 	wg.Wait()
 	return results
 }
 
+// isStale reports whether a cached result has outlived the max age. Results
+// cached before FetchedAt was recorded count as stale.
+func (m *ResolverManager) isStale(res *Result) bool {
+	if m.maxAge <= 0 {
+		return false
+	}
+	return res.FetchedAt == nil || time.Since(*res.FetchedAt) > m.maxAge
+}
+
+// revalidate asks the resolver that produced a stale result whether its
+// source changed. On 304 it returns a copy with a fresh FetchedAt; otherwise
+// nil, and the URL is resolved again.
+func (m *ResolverManager) revalidate(ctx context.Context, res *Result) *Result {
+	v := res.Validators
+	if v == nil {
+		return nil
+	}
+	for _, r := range m.resolvers {
+		if r.Name() != v.Resolver {
+			continue
+		}
+		rv, ok := r.(Revalidator)
+		if !ok {
+			return nil
+		}
+		notModified, err := rv.Revalidate(transport.WithAuditResolver(ctx, r.Name()), v)
+		if err != nil {
+			log.Printf("Revalidation of %s failed: %v", v.URL, err)
+			return nil
+		}
+		if !notModified {
+			return nil
+		}
+		fresh := *res
+		now := time.Now()
+		fresh.FetchedAt = &now
+		return &fresh
+	}
+	return nil
+}
+
+// resolveKey runs the pipeline for one cache key: unwrap, expand, then the
+// resolvers. What gets fetched is raw, the URL as the user supplied it; the
+// canonical key only names the cache entry and the result's CleanURL.
+func (m *ResolverManager) resolveKey(ctx context.Context, key, raw string) *Result {
+	u, err := url.Parse(raw)
+	if err != nil {
+		log.Printf("Failed to parse URL %s: %v", raw, err)
+		return nil
+	}
+	start := u
+
+	// javascript: and data: links have nothing to fetch
+	if isInlineScheme(u) {
+		res := inlineResult(u)
+		res.CleanURL = key
+		return res
+	}
+
+	// Peel off link wrappers before anything touches the network
+	var chain []Hop
+	if m.unwrapper != nil {
+		u, chain = m.unwrapper.Unwrap(u)
+	}
+
+	final, expanded, err := m.expand(ctx, u)
+	if err != nil {
+		log.Printf("Redirect expansion failed for %s: %v", key, err)
+		return nil
+	}
+	chain = joinChains(chain, expanded, final)
+	u = final
+
+	// A redirect to a javascript: or data: URI ends the chain there
+	if isInlineScheme(u) {
+		res := inlineResult(u)
+		res.RedirectChain = chain
+		res.CleanURL = key
+		return res
+	}
+
+	// Refuse to go anywhere near a known-malicious destination
+	if m.isBlocked(u) {
+		res := blockedResult(u)
+		if u.String() != raw {
+			res.FinalURL = u.String()
+		}
+		if len(chain) > 1 {
+			res.RedirectChain = chain
+		}
+		res.CleanURL = key
+		res.Reputation = m.tagReputation(chain, u)
+		if m.analyzer != nil {
+			res.Warnings = m.analyzer.Analyze(u.Hostname())
+		}
+		return res
+	}
+
+	for _, r := range m.resolvers {
+		if r.CanHandle(u) {
+			res, err := r.Resolve(transport.WithAuditResolver(ctx, r.Name()), u)
+			if err != nil {
+				log.Printf("Resolver %s failed for %s: %v", r.Name(), key, err)
+				continue // Try next resolver if possible
+			}
+
+			if res != nil && res.Title != "" {
+				if res.FinalURL == "" && u.String() != raw {
+					res.FinalURL = u.String()
+				}
+				// A single hop is just the URL answering directly; only real chains are reported
+				chain = extendChain(chain, res.RedirectChain)
+				res.RedirectChain = nil
+				if len(chain) > 1 {
+					res.RedirectChain = chain
+				}
+				res.CleanURL = key
+				res.Reputation = m.tagReputation(res.RedirectChain, finalURL(res, u))
+				applySecurity(res, chain, start, finalURL(res, u))
+				if m.analyzer != nil {
+					res.Warnings = m.analyzer.Analyze(finalURL(res, u).Hostname())
+				}
+				return res
+			}
+		}
+	}
+	return nil
+}
+
 func (m *ResolverManager) ResolveVideoIDs(ctx context.Context, ids []string) map[string]string {
 	// For backward compatibility, we convert video IDs to YouTube URLs
 	urls := make([]string, len(ids))
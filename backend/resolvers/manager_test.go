@@ -2,23 +2,32 @@ package resolvers
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/reputation"
+	"github.com/sph/youtube-url-replacer/backend/transport"
 )
 
 type MockCache struct {
-	store map[string]string
+	store map[string]*Result
 }
 
-func (m *MockCache) Get(key string) (string, bool) {
+func (m *MockCache) Get(key string) (*Result, bool) {
 	val, ok := m.store[key]
 	return val, ok
 }
-func (m *MockCache) Set(key string, title string) {
-	m.store[key] = title
+func (m *MockCache) Set(key string, res *Result) {
+	m.store[key] = res
 }
-func (m *MockCache) GetMulti(keys []string) map[string]string {
-	res := make(map[string]string)
+func (m *MockCache) GetMulti(keys []string) map[string]*Result {
+	res := make(map[string]*Result)
 	for _, k := range keys {
 		if val, ok := m.store[k]; ok {
 			res[k] = val
@@ -40,7 +49,7 @@ func (r *MockResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error)
 }
 
 func TestResolverManager(t *testing.T) {
-	cache := &MockCache{store: make(map[string]string)}
+	cache := &MockCache{store: make(map[string]*Result)}
 	manager := NewResolverManager(cache)
 
 	r1 := &MockResolver{name: "r1", canHandle: true, title: "Title 1"}
@@ -56,8 +65,8 @@ func TestResolverManager(t *testing.T) {
 	}
 
 	// Check cache
-	if cache.store["https://example.com/1"] != "Title 1" {
-		t.Errorf("Expected Title 1 in cache, got %s", cache.store["https://example.com/1"])
+	if cached := cache.store["https://example.com/1"]; cached == nil || cached.Title != "Title 1" || cached.Platform != "r1" {
+		t.Errorf("Expected full result for Title 1 in cache, got %+v", cached)
 	}
 
 	// Test ResolveVideoIDs (Legacy)
@@ -66,3 +75,358 @@ func TestResolverManager(t *testing.T) {
 		t.Errorf("Expected Title 1 for video ID abc, got %s", idResults["abc"])
 	}
 }
+
+type MockExpander struct {
+	calls      int
+	shorteners map[string]bool
+	target     string
+}
+
+func (e *MockExpander) Expand(ctx context.Context, u *url.URL) (*url.URL, []Hop, error) {
+	e.calls++
+	final, err := url.Parse(e.target)
+	return final, []Hop{{URL: u.String(), Status: 301}, {URL: e.target, Status: 200, CrossDomain: true}}, err
+}
+func (e *MockExpander) IsShortener(u *url.URL) bool { return e.shorteners[u.Host] }
+
+type MockFallback struct {
+	MockResolver
+}
+
+func (r *MockFallback) IsFallbackFor(u *url.URL) bool { return true }
+func (r *MockFallback) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
+	return &Result{Title: "Fallback for " + u.String(), Platform: r.name}, nil
+}
+
+func TestResolverManager_Expander(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Fallback URLs Are Expanded", func(t *testing.T) {
+		manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+		manager.Register(&MockFallback{MockResolver{name: "generic", canHandle: true}})
+		exp := &MockExpander{target: "https://example.com/final"}
+		manager.SetExpander(exp)
+
+		res := manager.ResolveMulti(ctx, []string{"https://lnkd.in/abc"})["https://lnkd.in/abc"]
+		if res == nil || res.Title != "Fallback for https://example.com/final" {
+			t.Fatalf("Expected expanded URL to be resolved, got %+v", res)
+		}
+		if res.FinalURL != "https://example.com/final" {
+			t.Errorf("Expected final URL to be recorded, got %q", res.FinalURL)
+		}
+		if len(res.RedirectChain) != 2 || !res.RedirectChain[1].CrossDomain {
+			t.Errorf("Expected redirect chain to be attached, got %+v", res.RedirectChain)
+		}
+	})
+
+	t.Run("Specialist URLs Skip Expansion", func(t *testing.T) {
+		manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+		manager.Register(&MockResolver{name: "special", canHandle: true, title: "Special"})
+		exp := &MockExpander{target: "https://example.com/final"}
+		manager.SetExpander(exp)
+
+		manager.ResolveMulti(ctx, []string{"https://special.example/1"})
+		if exp.calls != 0 {
+			t.Errorf("Expected no expansion for specialist URL, got %d calls", exp.calls)
+		}
+	})
+
+	t.Run("Shorteners Are Always Expanded", func(t *testing.T) {
+		manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+		manager.Register(&MockResolver{name: "special", canHandle: true, title: "Special"})
+		exp := &MockExpander{target: "https://example.com/final", shorteners: map[string]bool{"bit.ly": true}}
+		manager.SetExpander(exp)
+
+		manager.ResolveMulti(ctx, []string{"https://bit.ly/xyz"})
+		if exp.calls != 1 {
+			t.Errorf("Expected shortener to be expanded once, got %d calls", exp.calls)
+		}
+	})
+}
+
+type countingResolver struct {
+	mu    sync.Mutex
+	calls int
+	seen  []string
+}
+
+func (r *countingResolver) Name() string { return "counting" }
+func (r *countingResolver) CanHandle(u *url.URL) bool { return true }
+func (r *countingResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
+	r.mu.Lock()
+	r.calls++
+	r.seen = append(r.seen, u.String())
+	r.mu.Unlock()
+	return &Result{Title: "Title for " + u.String()}, nil
+}
+
+// hintResolver follows an in-page redirect to a plain-http page on another site
+type hintResolver struct {
+	MockResolver
+}
+
+func (r *hintResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
+	next := "http://other.example.net/page"
+	return &Result{Title: "Page", Platform: r.name, FinalURL: next, RedirectChain: []Hop{
+		{URL: u.String(), Method: "GET", Status: 200},
+		{URL: next, Method: "GET", Status: 200, CrossDomain: true, Downgrade: true},
+	}}, nil
+}
+
+func TestResolverManager_ResolverHops(t *testing.T) {
+	manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+	manager.Register(&hintResolver{MockResolver{name: "hints", canHandle: true}})
+	manager.SetExpander(&MockExpander{target: "https://example.com/final", shorteners: map[string]bool{"sho.rt": true}})
+
+	res := manager.ResolveMulti(context.Background(), []string{"https://sho.rt/x"})["https://sho.rt/x"]
+	if res == nil {
+		t.Fatal("Expected a result")
+	}
+	// The resolver's first hop is where the expansion ended, so it isn't repeated
+	want := []string{"https://sho.rt/x", "https://example.com/final", "http://other.example.net/page"}
+	if len(res.RedirectChain) != len(want) {
+		t.Fatalf("Expected %d hops, got %+v", len(want), res.RedirectChain)
+	}
+	for i, hop := range res.RedirectChain {
+		if hop.URL != want[i] {
+			t.Errorf("Hop %d: expected %s, got %s", i, want[i], hop.URL)
+		}
+	}
+	if last := res.RedirectChain[2]; !last.Downgrade || !last.CrossDomain || last.Method != "GET" {
+		t.Errorf("Expected the followed hop's flags, got %+v", last)
+	}
+	if res.Security == nil || !res.Security.Downgrade {
+		t.Errorf("Expected the downgrade to be flagged, got %+v", res.Security)
+	}
+}
+
+func TestResolverManager_CanonicalKeys(t *testing.T) {
+	cache := &MockCache{store: make(map[string]*Result)}
+	manager := NewResolverManager(cache)
+	manager.SetUnwrapper(nil)
+	r := &countingResolver{}
+	manager.Register(r)
+
+	urls := []string{
+		"https://example.com/post?utm_source=twitter",
+		"https://example.com/post?fbclid=abc",
+		"https://EXAMPLE.com/post#comments",
+	}
+	results := manager.ResolveMulti(context.Background(), urls)
+
+	if r.calls != 1 {
+		t.Errorf("Expected variants to share one resolution, got %d", r.calls)
+	}
+	// The canonical form is only the cache key; the URL fetched is the one supplied
+	if len(r.seen) != 1 || r.seen[0] != urls[0] {
+		t.Errorf("Expected the resolver to get %s, got %v", urls[0], r.seen)
+	}
+	if len(cache.store) != 1 || cache.store["https://example.com/post"] == nil {
+		t.Errorf("Expected a single cache entry under the clean URL, got %v", cache.store)
+	}
+	for _, raw := range urls {
+		res := results[raw]
+		if res == nil || res.CleanURL != "https://example.com/post" {
+			t.Errorf("Expected clean URL for %s, got %+v", raw, res)
+		}
+	}
+
+	// A later request with yet another tracker hits the cache
+	manager.ResolveMulti(context.Background(), []string{"https://example.com/post?gclid=1"})
+	if r.calls != 1 {
+		t.Errorf("Expected cache hit for tracker variant, got %d resolutions", r.calls)
+	}
+}
+
+func TestResolverManager_Reputation(t *testing.T) {
+	ctx := context.Background()
+	feed := filepath.Join(t.TempDir(), "domains.txt")
+	if err := os.WriteFile(feed, []byte("phish.test\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db := reputation.NewDB(reputation.Feed{Format: reputation.FormatDomains, Path: feed, Verdict: reputation.Malicious})
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Malicious Destinations Are Not Fetched", func(t *testing.T) {
+		manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+		r := &countingResolver{}
+		manager.Register(r)
+		manager.SetExpander(&MockExpander{target: "https://login.phish.test/", shorteners: map[string]bool{"lnkd.in": true}})
+		manager.SetReputation(db)
+
+		res := manager.ResolveMulti(ctx, []string{"https://lnkd.in/abc"})["https://lnkd.in/abc"]
+		if r.calls != 0 {
+			t.Errorf("Expected no resolver to run for a malicious destination, got %d calls", r.calls)
+		}
+		if res == nil || res.Platform != "Blocked" || res.Reputation != "malicious" {
+			t.Fatalf("Expected a blocked result, got %+v", res)
+		}
+		if len(res.RedirectChain) != 2 || res.RedirectChain[0].Reputation != "clean" || res.RedirectChain[1].Reputation != "malicious" {
+			t.Errorf("Expected every hop to be tagged, got %+v", res.RedirectChain)
+		}
+	})
+
+	t.Run("Clean Destinations Are Tagged", func(t *testing.T) {
+		manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+		manager.Register(&MockResolver{name: "r1", canHandle: true, title: "Title 1"})
+		manager.SetReputation(db)
+
+		res := manager.ResolveMulti(ctx, []string{"https://example.com/1"})["https://example.com/1"]
+		if res == nil || res.Reputation != "clean" {
+			t.Errorf("Expected clean verdict, got %+v", res)
+		}
+	})
+
+	t.Run("Cached Results Follow Feed Reloads", func(t *testing.T) {
+		listed := filepath.Join(t.TempDir(), "domains.txt")
+		if err := os.WriteFile(listed, []byte("unrelated.example\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		db := reputation.NewDB(reputation.Feed{Format: reputation.FormatDomains, Path: listed, Verdict: reputation.Malicious})
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+		r := &countingResolver{}
+		manager.Register(r)
+		manager.SetReputation(db)
+
+		const link = "https://turned.example/page"
+		if res := manager.ResolveMulti(ctx, []string{link})[link]; res == nil || res.Reputation != "clean" {
+			t.Fatalf("Expected a clean result before the host is listed, got %+v", res)
+		}
+
+		if err := os.WriteFile(listed, []byte("turned.example\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		res := manager.ResolveMulti(ctx, []string{link})[link]
+		if res == nil || res.Platform != "Blocked" || res.Reputation != "malicious" || res.CleanURL != link {
+			t.Errorf("Expected the cached result to come back blocked, got %+v", res)
+		}
+		if r.calls != 1 {
+			t.Errorf("Expected the cache to answer, got %d resolver calls", r.calls)
+		}
+
+		// Delisting lets the URL resolve again
+		if err := os.WriteFile(listed, []byte("unrelated.example\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if res := manager.ResolveMulti(ctx, []string{link})[link]; res == nil || res.Platform == "Blocked" || res.Reputation != "clean" {
+			t.Errorf("Expected a clean result after delisting, got %+v", res)
+		}
+	})
+
+	t.Run("Guard Covers Every Request", func(t *testing.T) {
+		manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+		manager.SetReputation(db)
+		guarded := withFetchGuard(ctx, manager.fetchGuard)
+
+		policy := transport.DefaultPolicy()
+		audit := &auditLog{}
+		policy.Audit = audit
+		req, _ := http.NewRequestWithContext(guarded, http.MethodGet, "https://cdn.phish.test/x", nil)
+		if _, err := SafeHttpClient(time.Second, policy).Do(req); !errors.Is(err, ErrBlockedHost) {
+			t.Errorf("Expected ErrBlockedHost, got %v", err)
+		}
+		// The refusal never reaches the audited transport, so the guard records it
+		if len(audit.records) != 1 || audit.records[0].Decision != "blocked" || audit.records[0].Reason != "malicious_host" ||
+			audit.records[0].Host != "cdn.phish.test" {
+			t.Errorf("Expected one blocked record, got %+v", audit.records)
+		}
+	})
+}
+
+// auditLog collects audit records from a single goroutine
+type auditLog struct {
+	records []transport.AuditRecord
+}
+
+func (a *auditLog) Record(rec transport.AuditRecord) { a.records = append(a.records, rec) }
+
+// revalidatingResolver answers conditional requests from a version counter
+type revalidatingResolver struct {
+	countingResolver
+	version     string
+	revalidated int
+	err         error
+}
+
+func (r *revalidatingResolver) Name() string { return "revalidating" }
+func (r *revalidatingResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	res, _ := r.countingResolver.Resolve(ctx, u)
+	res.Validators = &Validators{Resolver: r.Name(), URL: u.String(), ETag: r.version}
+	return res, nil
+}
+func (r *revalidatingResolver) Revalidate(ctx context.Context, v *Validators) (bool, error) {
+	r.revalidated++
+	if r.err != nil {
+		return false, r.err
+	}
+	return v.ETag == r.version, nil
+}
+
+func TestResolverManager_Revalidation(t *testing.T) {
+	cache := &MockCache{store: make(map[string]*Result)}
+	manager := NewResolverManager(cache)
+	manager.SetUnwrapper(nil)
+	manager.SetMaxAge(time.Hour)
+	r := &revalidatingResolver{version: `"v1"`}
+	manager.Register(r)
+
+	const key = "https://example.com/post"
+	age := func(d time.Duration) {
+		fetched := time.Now().Add(-d)
+		cache.store[key].FetchedAt = &fetched
+	}
+
+	manager.ResolveMulti(context.Background(), []string{key})
+	if r.calls != 1 || cache.store[key].FetchedAt == nil {
+		t.Fatalf("Expected a resolution stamped with FetchedAt, got %d calls, %+v", r.calls, cache.store[key])
+	}
+
+	// Fresh entries are served as they are
+	manager.ResolveMulti(context.Background(), []string{key})
+	if r.calls != 1 || r.revalidated != 0 {
+		t.Errorf("Expected a fresh cache hit, got %d calls and %d revalidations", r.calls, r.revalidated)
+	}
+
+	// A stale entry that hasn't changed is extended without resolving again
+	age(2 * time.Hour)
+	manager.ResolveMulti(context.Background(), []string{key})
+	if r.calls != 1 || r.revalidated != 1 {
+		t.Errorf("Expected a revalidation only, got %d calls and %d revalidations", r.calls, r.revalidated)
+	}
+	if time.Since(*cache.store[key].FetchedAt) > time.Minute {
+		t.Error("Expected the revalidated entry to be extended")
+	}
+
+	// A changed one is resolved again
+	age(2 * time.Hour)
+	r.version = `"v2"`
+	manager.ResolveMulti(context.Background(), []string{key})
+	if r.calls != 2 || cache.store[key].Validators.ETag != `"v2"` {
+		t.Errorf("Expected a fresh resolution, got %d calls, %+v", r.calls, cache.store[key].Validators)
+	}
+
+	// When the origin is down the stale entry is still served, but not extended
+	age(2 * time.Hour)
+	r.err = errors.New("origin down")
+	results := manager.ResolveMulti(context.Background(), []string{key})
+	if results[key] == nil || results[key].Title != "Title for "+key {
+		t.Errorf("Expected the stale result to be served, got %+v", results[key])
+	}
+	if time.Since(*cache.store[key].FetchedAt) < time.Hour {
+		t.Error("A stale result was stored as fresh")
+	}
+}
@@ -0,0 +1,233 @@
+package resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
+)
+
+// OEmbedProvider describes an oEmbed endpoint and the URL schemes it serves
+type OEmbedProvider struct {
+	Name     string
+	Endpoint string
+	Schemes  []string
+
+	patterns []*regexp.Regexp
+}
+
+// defaultOEmbedProviders is the bundled provider registry. Other sites are
+// covered by OpenGraphResolver, which follows the page's own
+// <link rel="alternate"> discovery link (see SetOEmbed).
+var defaultOEmbedProviders = []OEmbedProvider{
+	{Name: "Vimeo", Endpoint: "https://vimeo.com/api/oembed.json", Schemes: []string{
+		"https://vimeo.com/*", "https://vimeo.com/*/*/video/*", "https://player.vimeo.com/video/*",
+	}},
+	{Name: "SoundCloud", Endpoint: "https://soundcloud.com/oembed", Schemes: []string{
+		"http://soundcloud.com/*", "https://soundcloud.com/*", "https://on.soundcloud.com/*",
+	}},
+	{Name: "Flickr", Endpoint: "https://www.flickr.com/services/oembed/", Schemes: []string{
+		"http://*.flickr.com/photos/*", "https://*.flickr.com/photos/*", "https://flic.kr/p/*",
+	}},
+	{Name: "Spotify", Endpoint: "https://open.spotify.com/oembed", Schemes: []string{
+		"https://open.spotify.com/*",
+	}},
+	{Name: "TikTok", Endpoint: "https://www.tiktok.com/oembed", Schemes: []string{
+		"https://www.tiktok.com/*/video/*", "https://www.tiktok.com/@*",
+	}},
+	{Name: "Reddit", Endpoint: "https://www.reddit.com/oembed", Schemes: []string{
+		"https://reddit.com/r/*/comments/*", "https://www.reddit.com/r/*/comments/*",
+	}},
+	{Name: "Twitter", Endpoint: "https://publish.twitter.com/oembed", Schemes: []string{
+		"https://twitter.com/*/status/*", "https://x.com/*/status/*",
+	}},
+	{Name: "Dailymotion", Endpoint: "https://www.dailymotion.com/services/oembed", Schemes: []string{
+		"https://www.dailymotion.com/video/*", "https://dai.ly/*",
+	}},
+	{Name: "Mixcloud", Endpoint: "https://app.mixcloud.com/oembed/", Schemes: []string{
+		"https://www.mixcloud.com/*/*/",
+	}},
+}
+
+// oembedResponse is the subset of the oEmbed 1.0 response we consume
+type oembedResponse struct {
+	Type            string  `json:"type"`
+	Version         string  `json:"version"`
+	Title           string  `json:"title"`
+	Description     string  `json:"description"`
+	AuthorName      string  `json:"author_name"`
+	AuthorURL       string  `json:"author_url"`
+	ProviderName    string  `json:"provider_name"`
+	ProviderURL     string  `json:"provider_url"`
+	URL             string  `json:"url"`
+	ThumbnailURL    string  `json:"thumbnail_url"`
+	ThumbnailWidth  flexInt `json:"thumbnail_width"`
+	ThumbnailHeight flexInt `json:"thumbnail_height"`
+	Width           flexInt `json:"width"`
+	Height          flexInt `json:"height"`
+}
+
+// flexInt accepts both numbers and numeric strings, since providers disagree
+type flexInt int
+
+func (f *flexInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*f = 0
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil // Ignore malformed dimensions rather than failing the whole response
+	}
+	*f = flexInt(v)
+	return nil
+}
+
+// OEmbedResolver resolves URLs through the oEmbed protocol using the bundled
+// provider registry. Endpoints discovered on other pages are fetched with
+// FetchEndpoint by the resolver that already has the page.
+type OEmbedResolver struct {
+	client    *http.Client
+	providers []OEmbedProvider
+}
+
+// NewOEmbedResolver creates a resolver using the bundled provider registry
+func NewOEmbedResolver() *OEmbedResolver {
+	r := &OEmbedResolver{
+		client: SafeHttpClient(2*time.Second, transport.DefaultPolicy()),
+	}
+	for _, p := range defaultOEmbedProviders {
+		r.AddProvider(p)
+	}
+	return r
+}
+
+// AddProvider registers an additional oEmbed provider
+func (r *OEmbedResolver) AddProvider(p OEmbedProvider) {
+	p.patterns = nil
+	for _, scheme := range p.Schemes {
+		p.patterns = append(p.patterns, schemePattern(scheme))
+	}
+	r.providers = append(r.providers, p)
+}
+
+// schemePattern turns an oEmbed URL scheme ("https://*.example.com/v/*") into an anchored regexp
+func schemePattern(scheme string) *regexp.Regexp {
+	parts := strings.Split(scheme, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("(?i)^" + strings.Join(parts, ".*") + "$")
+}
+
+func (r *OEmbedResolver) Name() string {
+	return "oembed"
+}
+
+// SetEgressPolicy replaces the client's egress policy
+func (r *OEmbedResolver) SetEgressPolicy(p *transport.Policy) {
+	r.client = SafeHttpClient(r.client.Timeout, p)
+}
+
+func (r *OEmbedResolver) CanHandle(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && r.providerFor(u) != nil
+}
+
+func (r *OEmbedResolver) providerFor(u *url.URL) *OEmbedProvider {
+	s := u.String()
+	for i := range r.providers {
+		for _, p := range r.providers[i].patterns {
+			if p.MatchString(s) {
+				return &r.providers[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (r *OEmbedResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
+	p := r.providerFor(u)
+	if p == nil {
+		return nil, nil
+	}
+	endpoint, err := url.Parse(p.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := endpoint.Query()
+	q.Set("url", u.String())
+	q.Set("format", "json")
+	endpoint.RawQuery = q.Encode()
+
+	res, err := r.fetchEndpoint(ctx, endpoint.String())
+	if err != nil || res == nil {
+		return nil, err
+	}
+	if res.Platform == "" {
+		res.Platform = p.Name
+	}
+	if res.SiteName == "" {
+		res.SiteName = p.Name
+	}
+	return res, nil
+}
+
+// FetchEndpoint fetches an endpoint a page advertised through oEmbed
+// discovery. It returns nil when the provider says the page isn't embeddable.
+func (r *OEmbedResolver) FetchEndpoint(ctx context.Context, endpoint string) (*Result, error) {
+	ctx = transport.WithAuditResolver(ctx, r.Name())
+	return r.fetchEndpoint(ctx, endpoint)
+}
+
+func (r *OEmbedResolver) fetchEndpoint(ctx context.Context, endpoint string) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized {
+		return nil, nil // Not embeddable; let the generic resolver try
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oembed endpoint returned status %d", resp.StatusCode)
+	}
+
+	var data oembedResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 256*1024)).Decode(&data); err != nil {
+		return nil, err
+	}
+	if data.Title == "" {
+		return nil, fmt.Errorf("oembed response has no title")
+	}
+
+	res := &Result{
+		Title:       strings.TrimSpace(data.Title),
+		Description: data.Description,
+		Platform:    data.ProviderName,
+		SiteName:    data.ProviderName,
+		Author:      data.AuthorName,
+	}
+	if data.ThumbnailURL != "" {
+		res.Image = &Image{URL: data.ThumbnailURL, Width: int(data.ThumbnailWidth), Height: int(data.ThumbnailHeight)}
+	} else if data.Type == "photo" && data.URL != "" {
+		res.Image = &Image{URL: data.URL, Width: int(data.Width), Height: int(data.Height)}
+	}
+	return res, nil
+}
@@ -0,0 +1,90 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
+)
+
+func TestOEmbedResolver(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") != "json" {
+			http.Error(w, "format required", http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"type":"video","version":"1.0","title":"Video for %s","author_name":"Some Artist",
+			"provider_name":"TestTube","thumbnail_url":"https://img.example/t.jpg","thumbnail_width":"640","thumbnail_height":360}`,
+			r.URL.Query().Get("url"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	t.Run("Registry Match", func(t *testing.T) {
+		r := NewOEmbedResolver()
+		r.SetEgressPolicy(transport.LocalPolicy())
+		r.AddProvider(OEmbedProvider{Name: "Test", Endpoint: ts.URL + "/oembed", Schemes: []string{"https://videos.test/watch/*"}})
+
+		u, _ := url.Parse("https://videos.test/watch/42")
+		if !r.CanHandle(u) {
+			t.Fatal("Expected registry URL to be handled")
+		}
+		res, err := r.Resolve(ctx, u)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if res.Title != "Video for https://videos.test/watch/42" {
+			t.Errorf("Unexpected title %q", res.Title)
+		}
+		if res.Author != "Some Artist" || res.SiteName != "TestTube" {
+			t.Errorf("Unexpected author/site %q/%q", res.Author, res.SiteName)
+		}
+		if res.Image == nil || res.Image.Width != 640 || res.Image.Height != 360 {
+			t.Errorf("Unexpected image %+v", res.Image)
+		}
+	})
+
+	t.Run("Unlisted Sites Are Left To OpenGraph", func(t *testing.T) {
+		r := NewOEmbedResolver()
+		u, _ := url.Parse(ts.URL + "/blog/post")
+		if r.CanHandle(u) {
+			t.Error("Expected URLs outside the registry not to be claimed")
+		}
+		if res, err := r.Resolve(ctx, u); res != nil || err != nil {
+			t.Errorf("Expected nil result, got %+v, %v", res, err)
+		}
+	})
+
+	t.Run("Scheme Patterns", func(t *testing.T) {
+		r := NewOEmbedResolver()
+		r.SetEgressPolicy(transport.LocalPolicy())
+		tests := []struct {
+			url  string
+			want string
+		}{
+			{"https://vimeo.com/76979871", "Vimeo"},
+			{"https://open.spotify.com/track/abc", "Spotify"},
+			{"https://www.reddit.com/r/golang/comments/xyz/title/", "Reddit"},
+			{"https://live.staticflickr.com/photos/1", ""},
+			{"https://www.flickr.com/photos/user/123", "Flickr"},
+		}
+		for _, tc := range tests {
+			u, _ := url.Parse(tc.url)
+			got := ""
+			if p := r.providerFor(u); p != nil {
+				got = p.Name
+			}
+			if got != tc.want {
+				t.Errorf("providerFor(%s) = %q; want %q", tc.url, got, tc.want)
+			}
+		}
+	})
+}
@@ -2,19 +2,43 @@ package resolvers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
+)
+
+var (
+	// Titles that mean we landed on a redirect page rather than the content
+	interstitialTitleRegex = regexp.MustCompile(`(?i)^(redirect|loading|please wait|one moment|just a moment|moved|you are being redirected|leaving)`)
+	refreshURLRegex        = regexp.MustCompile(`(?i)^\s*(\d*\.?\d*)\s*(?:[;,]\s*(?:url\s*=\s*)?(.*))?$`)
 )
 
+// maxRefreshDelay bounds which meta-refresh pages count as interstitials;
+// longer delays are usually auto-reloading content pages
+const maxRefreshDelay = 10 * time.Second
+
 type OpenGraphResolver struct {
-	client *http.Client
+	client  *http.Client
+	robots  *Robots
+	oembed  *OEmbedResolver
+	maxHops int
 }
 
 func NewOpenGraphResolver() *OpenGraphResolver {
 	return &OpenGraphResolver{
-		client: SafeHttpClient(2 * time.Second),
+		client:  SafeHttpClient(2*time.Second, transport.DefaultPolicy()),
+		robots:  NewRobots(),
+		maxHops: 3,
 	}
 }
 
@@ -22,12 +46,215 @@ func (r *OpenGraphResolver) Name() string {
 	return "opengraph"
 }
 
+// SetEgressPolicy replaces the client's egress policy
+func (r *OpenGraphResolver) SetEgressPolicy(p *transport.Policy) {
+	r.client = SafeHttpClient(r.client.Timeout, p)
+	if r.robots != nil {
+		r.robots.SetEgressPolicy(p)
+	}
+}
+
+// SetRobots replaces the robots.txt checker; nil fetches pages regardless of robots.txt
+func (r *OpenGraphResolver) SetRobots(robots *Robots) {
+	r.robots = robots
+}
+
+// SetOEmbed enables oEmbed discovery: pages that advertise an oEmbed endpoint
+// are described by it, fetched through o. nil skips discovery.
+func (r *OpenGraphResolver) SetOEmbed(o *OEmbedResolver) {
+	r.oembed = o
+}
+
 func (r *OpenGraphResolver) CanHandle(u *url.URL) bool {
 	// Generic fallback handles everything that looks like a valid http/https URL
 	return u.Scheme == "http" || u.Scheme == "https"
 }
 
+func (r *OpenGraphResolver) IsFallbackFor(u *url.URL) bool {
+	return true
+}
+
+// Resolve fetches the page and follows meta-refresh, JavaScript location and
+// AMP canonical links within the hop budget, returning the best result found.
+// When hints were followed, the pages fetched up to the best one are listed in
+// its RedirectChain.
 func (r *OpenGraphResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
+	tracker := newRedirectTracker(r.maxHops)
+	var best *Result
+	var hops []Hop
+	bestHops := 0
+
+	// done attaches the hops that led to best
+	done := func() (*Result, error) {
+		if bestHops > 1 {
+			best.RedirectChain = hops[:bestHops]
+		}
+		return best, nil
+	}
+
+	for {
+		if err := tracker.visit(u.String()); err != nil {
+			return nil, err
+		}
+
+		if r.robots != nil {
+			if err := r.robots.Check(ctx, u); err != nil {
+				if best != nil {
+					return done()
+				}
+				if errors.Is(err, ErrRobotsDisallowed) || errors.Is(err, ErrCrawlDelay) {
+					return robotsResult(u), nil
+				}
+				return nil, err
+			}
+		}
+
+		res, next, err := r.fetch(ctx, u)
+		if err != nil {
+			if best != nil {
+				return done() // Keep what the previous hop gave us
+			}
+			return nil, err
+		}
+		var prev *Hop
+		if len(hops) > 0 {
+			prev = &hops[len(hops)-1]
+		}
+		hop := newHop(u, http.StatusOK, prev)
+		hop.Method = http.MethodGet
+		hops = append(hops, hop)
+		if res.Title != "" {
+			best = res
+			bestHops = len(hops)
+		}
+
+		if next == nil || !tracker.next() {
+			break
+		}
+		u = next
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no title found")
+	}
+	return done()
+}
+
+// fetch retrieves a single URL and returns its metadata plus the next URL to follow, if any
+func (r *OpenGraphResolver) fetch(ctx context.Context, u *url.URL) (*Result, *url.URL, error) {
+	// Links that look like downloads only need their first bytes
+	ranged := extensionRisk(u.Path) != ""
+	resp, err := r.get(ctx, u, ranged)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	// Only HTML is scanned for metadata; everything else is described by type
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = ""
+	}
+	if mediaType != "" && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		res, err := r.resolveFile(ctx, resp, mediaType)
+		return res, nil, err
+	}
+
+	// A download-looking URL that serves a page needs the page in full
+	if resp.StatusCode == http.StatusPartialContent {
+		resp.Body.Close()
+		if resp, err = r.get(ctx, u, false); err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+	}
+
+	// Read a limited amount of data to avoid memory issues (e.g., 512KB)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 512*1024))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Free the origin's politeness slot before oEmbed discovery asks it again
+	resp.Body.Close()
+
+	pageURL := resp.Request.URL
+	res, hints := parsePage(string(body))
+	res.Platform = "Generic"
+	res.FinalURL = pageURL.String()
+	res.ContentType = mediaType
+	res.Security = describeTLS(resp.TLS)
+	if res.Language == "" {
+		res.Language = resp.Header.Get("Content-Language")
+	}
+	absolutizeURLs(res, pageURL)
+	res.Validators = validatorsFrom(r.Name(), pageURL.String(), resp.Header)
+
+	next := nextHop(pageURL, res, hints)
+	if next == nil && hints.OEmbed != "" && r.oembed != nil {
+		r.discoverOEmbed(ctx, pageURL, hints.OEmbed, res)
+	}
+	return res, next, nil
+}
+
+// discoverOEmbed fills res from the oEmbed endpoint the page advertised. The
+// page itself stays the source of the URLs, TLS details and validators; a
+// failing endpoint leaves the page's own metadata in place.
+func (r *OpenGraphResolver) discoverOEmbed(ctx context.Context, pageURL *url.URL, href string, res *Result) {
+	endpoint, err := pageURL.Parse(strings.TrimSpace(href))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return
+	}
+	embed, err := r.oembed.FetchEndpoint(ctx, endpoint.String())
+	if err != nil {
+		log.Printf("oEmbed discovery failed for %s: %v", pageURL.Hostname(), err)
+		return
+	}
+	if embed == nil {
+		return
+	}
+	res.Title = embed.Title
+	res.Platform = embed.Platform
+	if res.Platform == "" {
+		res.Platform = "oEmbed"
+	}
+	if embed.Description != "" {
+		res.Description = embed.Description
+	}
+	if embed.SiteName != "" {
+		res.SiteName = embed.SiteName
+	}
+	if embed.Author != "" {
+		res.Author = embed.Author
+	}
+	if embed.Image != nil {
+		res.Image = embed.Image
+	}
+}
+
+// Revalidate asks the page's origin whether it changed since it was fetched,
+// still subject to robots.txt
+func (r *OpenGraphResolver) Revalidate(ctx context.Context, v *Validators) (bool, error) {
+	u, err := url.Parse(v.URL)
+	if err != nil {
+		return false, err
+	}
+	if r.robots != nil {
+		if err := r.robots.Check(ctx, u); err != nil {
+			return false, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", v.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
+	return conditionalGet(r.client, req, v)
+}
+
+// get issues the GET for fetch. With ranged set only the first riskSniffBytes
+// are requested; servers that ignore the range answer 200 and are read as usual.
+func (r *OpenGraphResolver) get(ctx context.Context, u *url.URL, ranged bool) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
 		return nil, err
@@ -35,22 +262,64 @@ func (r *OpenGraphResolver) Resolve(ctx context.Context, u *url.URL) (*Result, e
 
 	// Set a User-Agent to avoid some basic blocks
 	req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
+	if ranged {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", riskSniffBytes-1))
+	}
 
 	resp, err := r.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && !(ranged && resp.StatusCode == http.StatusPartialContent) {
+		resp.Body.Close()
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
+	return resp, nil
+}
 
-	res, err := ExtractMetadata(resp.Body)
-	if err != nil {
-		return nil, err
+// nextHop decides whether a page is a waypoint rather than a destination
+func nextHop(pageURL *url.URL, res *Result, hints pageHints) *url.URL {
+	interstitial := res.Title == "" || interstitialTitleRegex.MatchString(res.Title)
+
+	var candidates []string
+	if target, delay, ok := parseRefresh(hints.Refresh); ok && delay <= maxRefreshDelay {
+		candidates = append(candidates, target)
+	}
+	if interstitial && hints.Script != "" {
+		candidates = append(candidates, hints.Script)
+	}
+	if (hints.IsAMP || interstitial) && hints.Canonical != "" {
+		candidates = append(candidates, hints.Canonical)
+	}
+	if res.Title == "" && hints.AMPHTML != "" {
+		// Script-rendered shells often have a server-rendered AMP twin
+		candidates = append(candidates, hints.AMPHTML)
 	}
 
-	res.Platform = "Generic"
-	return res, nil
+	for _, c := range candidates {
+		next, err := pageURL.Parse(strings.TrimSpace(c))
+		if err != nil || (next.Scheme != "http" && next.Scheme != "https") {
+			continue
+		}
+		next.Fragment = ""
+		if next.String() == pageURL.String() {
+			continue // Self-references aren't hops
+		}
+		return next
+	}
+	return nil
+}
+
+// parseRefresh splits a meta-refresh value like "0; url='https://example.com'"
+func parseRefresh(content string) (string, time.Duration, bool) {
+	m := refreshURLRegex.FindStringSubmatch(content)
+	if m == nil || m[2] == "" {
+		return "", 0, false
+	}
+	seconds, _ := strconv.ParseFloat(m[1], 64)
+	target := strings.Trim(strings.TrimSpace(m[2]), `"'`)
+	if target == "" {
+		return "", 0, false
+	}
+	return target, time.Duration(seconds * float64(time.Second)), true
 }
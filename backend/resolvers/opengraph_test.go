@@ -0,0 +1,228 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
+)
+
+func TestOpenGraphResolver(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Language", "de")
+		if _, err := w.Write([]byte(`<html><head><title>Artikel</title>
+			<meta property="og:image" content="/cover.jpg">
+			<link rel="canonical" href="/article?ref=canonical"></head></html>`)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	r := NewOpenGraphResolver()
+	r.SetEgressPolicy(transport.LocalPolicy())
+	u, _ := url.Parse(ts.URL + "/old")
+	res, err := r.Resolve(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if res.Title != "Artikel" {
+		t.Errorf("Expected title Artikel, got %q", res.Title)
+	}
+	if res.FinalURL != ts.URL+"/article" {
+		t.Errorf("Expected final URL %s/article, got %q", ts.URL, res.FinalURL)
+	}
+	if res.ContentType != "text/html" {
+		t.Errorf("Expected content type text/html, got %q", res.ContentType)
+	}
+	if res.Language != "de" {
+		t.Errorf("Expected language de, got %q", res.Language)
+	}
+	if res.Image == nil || res.Image.URL != ts.URL+"/cover.jpg" {
+		t.Errorf("Expected absolute image URL, got %+v", res.Image)
+	}
+	if res.CanonicalURL != ts.URL+"/article?ref=canonical" {
+		t.Errorf("Expected absolute canonical URL, got %q", res.CanonicalURL)
+	}
+}
+
+func TestOpenGraphResolver_OEmbedDiscovery(t *testing.T) {
+	var pageFetches, endpointFetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		endpointFetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"type":"video","version":"1.0","title":"Video for %s","author_name":"Some Artist","provider_name":"TestTube"}`,
+			r.URL.Query().Get("url"))
+	})
+	mux.HandleFunc("/blog/post", func(w http.ResponseWriter, r *http.Request) {
+		pageFetches.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Post</title><meta property="og:image" content="/cover.jpg">
+			<link rel="alternate" type="application/json+oembed" href="/oembed?url=post&amp;format=json">
+			</head></html>`)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		pageFetches.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Plain</title></head></html>`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	oembed := NewOEmbedResolver()
+	oembed.SetEgressPolicy(transport.LocalPolicy())
+	r := NewOpenGraphResolver()
+	r.SetEgressPolicy(transport.LocalPolicy())
+	r.SetOEmbed(oembed)
+	ctx := context.Background()
+
+	u, _ := url.Parse(ts.URL + "/blog/post")
+	res, err := r.Resolve(ctx, u)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if res.Title != "Video for post" || res.Author != "Some Artist" || res.Platform != "TestTube" {
+		t.Errorf("Expected the oEmbed metadata, got %+v", res)
+	}
+	if res.FinalURL != ts.URL+"/blog/post" || res.Image == nil || res.Image.URL != ts.URL+"/cover.jpg" {
+		t.Errorf("Expected the page's URL and image to stay, got %q %+v", res.FinalURL, res.Image)
+	}
+	// Discovery reads the page OpenGraph fetched anyway
+	if pageFetches.Load() != 1 || endpointFetches.Load() != 1 {
+		t.Errorf("Expected one page and one endpoint fetch, got %d and %d", pageFetches.Load(), endpointFetches.Load())
+	}
+
+	u, _ = url.Parse(ts.URL + "/plain")
+	if res, err := r.Resolve(ctx, u); err != nil || res.Title != "Plain" || res.Platform != "Generic" {
+		t.Errorf("Expected the plain page's own metadata, got %+v, %v", res, err)
+	}
+	if endpointFetches.Load() != 1 {
+		t.Error("Expected no endpoint fetch for a page without a discovery link")
+	}
+}
+
+func TestOpenGraphResolver_FollowsWaypoints(t *testing.T) {
+	page := func(html string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			if _, err := w.Write([]byte(html)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dest", page(`<html><head><title>Real Destination</title></head></html>`))
+	mux.HandleFunc("/refresh", page(`<html><head><title>Redirecting...</title>
+		<meta http-equiv="refresh" content="0; URL='/dest'"></head></html>`))
+	mux.HandleFunc("/js", page(`<html><head><title>Please wait</title></head>
+		<body><script>window.location.replace("\/dest");</script></body></html>`))
+	mux.HandleFunc("/amp", page(`<html amp lang="en"><head><title>AMP Copy</title>
+		<link rel="canonical" href="/dest"></head></html>`))
+	mux.HandleFunc("/reload", page(`<html><head><title>Live Scores</title>
+		<meta http-equiv="refresh" content="30"></head></html>`))
+	mux.HandleFunc("/self", page(`<html><head><title>Self</title>
+		<meta http-equiv="refresh" content="0;url=/self"><link rel="canonical" href="/self"></head></html>`))
+	mux.HandleFunc("/loop-a", page(`<html><head><meta http-equiv="refresh" content="0;url=/loop-b"></head></html>`))
+	mux.HandleFunc("/loop-b", page(`<html><head><meta http-equiv="refresh" content="0;url=/loop-a"></head></html>`))
+	mux.HandleFunc("/broken", page(`<html><head><title>Redirecting</title>
+		<meta http-equiv="refresh" content="0;url=/missing"></head></html>`))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	r := NewOpenGraphResolver()
+	r.SetEgressPolicy(transport.LocalPolicy())
+	ctx := context.Background()
+
+	tests := []struct {
+		path      string
+		wantTitle string
+		wantFinal string
+		wantErr   bool
+	}{
+		{"/refresh", "Real Destination", "/dest", false},
+		{"/js", "Real Destination", "/dest", false},
+		{"/amp", "Real Destination", "/dest", false},
+		{"/reload", "Live Scores", "/reload", false},
+		{"/self", "Self", "/self", false},
+		{"/loop-a", "", "", true},
+		{"/broken", "Redirecting", "/broken", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			u, _ := url.Parse(ts.URL + tc.path)
+			res, err := r.Resolve(ctx, u)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Expected error, got %+v", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if res.Title != tc.wantTitle {
+				t.Errorf("Expected title %q, got %q", tc.wantTitle, res.Title)
+			}
+			if res.FinalURL != ts.URL+tc.wantFinal {
+				t.Errorf("Expected final URL %s%s, got %q", ts.URL, tc.wantFinal, res.FinalURL)
+			}
+		})
+	}
+
+	// Followed hints are reported as hops; a page answering directly has none
+	u, _ := url.Parse(ts.URL + "/refresh")
+	res, err := r.Resolve(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Hop{
+		{URL: ts.URL + "/refresh", Method: "GET", Status: http.StatusOK},
+		{URL: ts.URL + "/dest", Method: "GET", Status: http.StatusOK},
+	}
+	if !reflect.DeepEqual(res.RedirectChain, want) {
+		t.Errorf("Unexpected redirect chain:\n got %+v\nwant %+v", res.RedirectChain, want)
+	}
+	u, _ = url.Parse(ts.URL + "/reload")
+	if res, err := r.Resolve(ctx, u); err != nil || res.RedirectChain != nil {
+		t.Errorf("Expected no chain for a page without hints, got %+v (%v)", res, err)
+	}
+}
+
+func TestParseRefresh(t *testing.T) {
+	tests := []struct {
+		content string
+		target  string
+		delay   time.Duration
+		ok      bool
+	}{
+		{"0; url=https://example.com/", "https://example.com/", 0, true},
+		{"5;URL='/next'", "/next", 5 * time.Second, true},
+		{`0, "/quoted"`, "/quoted", 0, true},
+		{"30", "", 0, false},
+		{"", "", 0, false},
+	}
+	for _, tc := range tests {
+		target, delay, ok := parseRefresh(tc.content)
+		if target != tc.target || delay != tc.delay || ok != tc.ok {
+			t.Errorf("parseRefresh(%q) = %q, %v, %v; want %q, %v, %v", tc.content, target, delay, ok, tc.target, tc.delay, tc.ok)
+		}
+	}
+}
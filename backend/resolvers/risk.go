@@ -0,0 +1,204 @@
+package resolvers
+
+import (
+	"bytes"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Risk categories, most severe first
+const (
+	RiskExecutable    = "executable"
+	RiskMobileApp     = "mobile-app"
+	RiskScript        = "script"
+	RiskMacroDocument = "macro-document"
+	RiskDiskImage     = "disk-image"
+	RiskArchive       = "archive"
+	RiskScriptURI     = "script-uri"
+	RiskDataURI       = "data-uri"
+)
+
+// riskSniffBytes is how much of a download is inspected for magic bytes
+const riskSniffBytes = 4096
+
+var riskSeverity = map[string]int{
+	RiskScriptURI:     8,
+	RiskExecutable:    7,
+	RiskMobileApp:     6,
+	RiskScript:        5,
+	RiskMacroDocument: 4,
+	RiskDiskImage:     3,
+	RiskDataURI:       2,
+	RiskArchive:       1,
+}
+
+// worseRisk returns the more severe of two risk categories
+func worseRisk(a, b string) string {
+	if riskSeverity[b] > riskSeverity[a] {
+		return b
+	}
+	return a
+}
+
+var riskyExtensions = map[string]string{
+	".exe": RiskExecutable, ".scr": RiskExecutable, ".com": RiskExecutable, ".pif": RiskExecutable,
+	".msi": RiskExecutable, ".msix": RiskExecutable, ".msp": RiskExecutable, ".dll": RiskExecutable,
+	".cpl": RiskExecutable, ".jar": RiskExecutable, ".dmg": RiskExecutable, ".pkg": RiskExecutable,
+	".deb": RiskExecutable, ".rpm": RiskExecutable, ".appimage": RiskExecutable, ".run": RiskExecutable,
+
+	".apk": RiskMobileApp, ".xapk": RiskMobileApp, ".aab": RiskMobileApp, ".ipa": RiskMobileApp,
+
+	".bat": RiskScript, ".cmd": RiskScript, ".ps1": RiskScript, ".vbs": RiskScript, ".vbe": RiskScript,
+	".jse": RiskScript, ".wsf": RiskScript, ".wsh": RiskScript, ".hta": RiskScript,
+	".lnk": RiskScript, ".sh": RiskScript, ".reg": RiskScript,
+
+	".docm": RiskMacroDocument, ".dotm": RiskMacroDocument, ".xlsm": RiskMacroDocument,
+	".xltm": RiskMacroDocument, ".xlam": RiskMacroDocument, ".pptm": RiskMacroDocument,
+	".potm": RiskMacroDocument, ".ppam": RiskMacroDocument, ".ppsm": RiskMacroDocument,
+	".sldm": RiskMacroDocument,
+
+	".iso": RiskDiskImage, ".img": RiskDiskImage, ".vhd": RiskDiskImage, ".vhdx": RiskDiskImage,
+
+	".zip": RiskArchive, ".rar": RiskArchive, ".7z": RiskArchive, ".cab": RiskArchive,
+	".ace": RiskArchive, ".tgz": RiskArchive, ".gz": RiskArchive, ".tar": RiskArchive,
+}
+
+var riskyContentTypes = map[string]string{
+	"application/x-msdownload":                      RiskExecutable,
+	"application/x-msdos-program":                   RiskExecutable,
+	"application/x-dosexec":                         RiskExecutable,
+	"application/vnd.microsoft.portable-executable": RiskExecutable,
+	"application/x-msi":                             RiskExecutable,
+	"application/java-archive":                      RiskExecutable,
+	"application/x-apple-diskimage":                 RiskExecutable,
+	"application/x-executable":                      RiskExecutable,
+	"application/vnd.android.package-archive":       RiskMobileApp,
+	"application/x-sh":                              RiskScript,
+	"text/x-shellscript":                            RiskScript,
+	"application/x-bat":                             RiskScript,
+	"application/hta":                               RiskScript,
+	"application/x-iso9660-image":                   RiskDiskImage,
+	"application/zip":                               RiskArchive,
+	"application/x-zip-compressed":                  RiskArchive,
+	"application/vnd.rar":                           RiskArchive,
+	"application/x-rar-compressed":                  RiskArchive,
+	"application/x-7z-compressed":                   RiskArchive,
+}
+
+// documentExtensions are ZIP-based formats that are harmless unless they carry macros
+var documentExtensions = map[string]bool{
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".odp": true, ".epub": true,
+}
+
+var oleMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// extensionRisk classifies a file name or URL path by its extension
+func extensionRisk(name string) string {
+	return riskyExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// contentTypeRisk classifies a media type; macro-enabled Office types all carry "macroenabled"
+func contentTypeRisk(mediaType string) string {
+	mediaType = strings.ToLower(mediaType)
+	if strings.Contains(mediaType, "macroenabled") {
+		return RiskMacroDocument
+	}
+	return riskyContentTypes[mediaType]
+}
+
+// magicRisk classifies the first bytes of a download; ext disambiguates ZIP and OLE containers
+func magicRisk(head []byte, ext string) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("MZ")),
+		bytes.HasPrefix(head, []byte("\x7fELF")),
+		bytes.HasPrefix(head, []byte{0xCF, 0xFA, 0xED, 0xFE}), // Mach-O 64-bit
+		bytes.HasPrefix(head, []byte{0xCE, 0xFA, 0xED, 0xFE}), // Mach-O 32-bit
+		bytes.HasPrefix(head, []byte{0xCA, 0xFE, 0xBA, 0xBE}): // Mach-O universal / Java class
+		return RiskExecutable
+	case bytes.HasPrefix(head, []byte("#!")):
+		return RiskScript
+	case bytes.HasPrefix(head, oleMagic):
+		// Legacy .doc/.xls/.ppt can all carry VBA; .msi shares the container
+		if ext == ".msi" || ext == ".msp" {
+			return RiskExecutable
+		}
+		return RiskMacroDocument
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		switch {
+		case bytes.Contains(head, []byte("vbaProject.bin")):
+			return RiskMacroDocument
+		case bytes.Contains(head, []byte("AndroidManifest.xml")) || bytes.Contains(head, []byte("classes.dex")):
+			return RiskMobileApp
+		case bytes.Contains(head, []byte("META-INF/MANIFEST.MF")):
+			return RiskExecutable
+		case documentExtensions[ext]:
+			return ""
+		}
+		return RiskArchive
+	case bytes.HasPrefix(head, []byte("Rar!\x1a\x07")), bytes.HasPrefix(head, []byte("7z\xbc\xaf\x27\x1c")):
+		return RiskArchive
+	}
+	return ""
+}
+
+// classifyDownload combines every signal about a fetched resource and
+// returns the most severe risk category, or "" when nothing looks dangerous
+func classifyDownload(u *url.URL, mediaType, contentDisposition string, head []byte) string {
+	name := path.Base(u.Path)
+	if contentDisposition != "" {
+		if _, params, err := mime.ParseMediaType(contentDisposition); err == nil && params["filename"] != "" {
+			name = params["filename"]
+		}
+	}
+	ext := strings.ToLower(path.Ext(name))
+
+	risk := extensionRisk(name)
+	risk = worseRisk(risk, contentTypeRisk(mediaType))
+	if head != nil {
+		magic := magicRisk(head, ext)
+		// A .docx that is really a plain ZIP container is still just a document
+		if magic == "" && risk == RiskArchive && documentExtensions[ext] {
+			return ""
+		}
+		risk = worseRisk(risk, magic)
+	}
+	return risk
+}
+
+// isInlineScheme reports whether the URL carries its content in the URL
+// itself (javascript:, data:) rather than pointing to a host
+func isInlineScheme(u *url.URL) bool {
+	switch strings.ToLower(u.Scheme) {
+	case "javascript", "vbscript", "data":
+		return true
+	}
+	return false
+}
+
+// inlineResult describes a javascript: or data: URI without fetching anything
+func inlineResult(u *url.URL) *Result {
+	scheme := strings.ToLower(u.Scheme)
+	if scheme == "data" {
+		mediaType := "text/plain"
+		if meta, _, ok := strings.Cut(u.Opaque, ","); ok && meta != "" {
+			if mt, _, err := mime.ParseMediaType(strings.TrimSuffix(meta, ";base64")); err == nil {
+				mediaType = mt
+			}
+		}
+		return &Result{
+			Title:       "Embedded " + mediaType + " content",
+			Description: "A data: URI; the content is inside the link itself",
+			Platform:    "File",
+			ContentType: mediaType,
+			Risk:        RiskDataURI,
+		}
+	}
+	return &Result{
+		Title:       "Script link",
+		Description: "Runs " + scheme + ": code instead of opening a page",
+		Platform:    "File",
+		Risk:        RiskScriptURI,
+	}
+}
@@ -0,0 +1,133 @@
+package resolvers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
+)
+
+func TestClassifyDownload(t *testing.T) {
+	zipWith := func(entry string) []byte {
+		return append([]byte("PK\x03\x04\x14\x00\x00\x00\x08\x00"), entry...)
+	}
+
+	tests := []struct {
+		name        string
+		url         string
+		mediaType   string
+		disposition string
+		head        []byte
+		want        string
+	}{
+		{"exe by extension", "https://example.com/setup.exe", "application/octet-stream", "", nil, RiskExecutable},
+		{"screensaver", "https://example.com/photo.SCR", "", "", nil, RiskExecutable},
+		{"apk by type", "https://example.com/get", "application/vnd.android.package-archive", "", nil, RiskMobileApp},
+		{"disposition wins over path", "https://example.com/invoice", "application/octet-stream", `attachment; filename="invoice.pdf.exe"`, nil, RiskExecutable},
+		{"macro-enabled type", "https://example.com/d", "application/vnd.ms-excel.sheet.macroEnabled.12", "", nil, RiskMacroDocument},
+		{"macro in docx", "https://example.com/report.docx", "application/octet-stream", "", zipWith("word/vbaProject.bin"), RiskMacroDocument},
+		{"plain docx", "https://example.com/report.docx", "application/zip", "", zipWith("word/document.xml"), ""},
+		{"legacy doc", "https://example.com/old.doc", "application/msword", "", oleMagic, RiskMacroDocument},
+		{"msi container", "https://example.com/tool.msi", "application/octet-stream", "", oleMagic, RiskExecutable},
+		{"PE disguised as pdf", "https://example.com/file.pdf", "application/pdf", "", []byte("MZ\x90\x00"), RiskExecutable},
+		{"elf", "https://example.com/bin", "application/octet-stream", "", []byte("\x7fELF\x02"), RiskExecutable},
+		{"shell script", "https://example.com/install", "text/plain", "", []byte("#!/bin/sh\ncurl"), RiskScript},
+		{"apk by magic", "https://example.com/app", "application/octet-stream", "", zipWith("AndroidManifest.xml"), RiskMobileApp},
+		{"zip", "https://example.com/files.zip", "application/zip", "", zipWith("a.txt"), RiskArchive},
+		{"iso", "https://example.com/disk.iso", "application/octet-stream", "", nil, RiskDiskImage},
+		{"pdf", "https://example.com/paper.pdf", "application/pdf", "", []byte("%PDF-1.7"), ""},
+		{"image", "https://example.com/pic.png", "image/png", "", []byte("\x89PNG"), ""},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := classifyDownload(u, tt.mediaType, tt.disposition, tt.head); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInlineURIs(t *testing.T) {
+	manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+	manager.SetEgressPolicy(transport.LocalPolicy())
+	manager.Register(NewOpenGraphResolver())
+
+	tests := []struct {
+		url      string
+		risk     string
+		mimeType string
+	}{
+		{"javascript:alert(document.cookie)", RiskScriptURI, ""},
+		{"data:text/html;base64,PGgxPmhpPC9oMT4=", RiskDataURI, "text/html"},
+		{"data:,hello", RiskDataURI, "text/plain"},
+	}
+	for _, tt := range tests {
+		res := manager.ResolveMulti(context.Background(), []string{tt.url})[tt.url]
+		if res == nil || res.Risk != tt.risk || res.ContentType != tt.mimeType {
+			t.Errorf("%s: expected risk %s and type %q, got %+v", tt.url, tt.risk, tt.mimeType, res)
+		}
+	}
+}
+
+func TestOpenGraphResolver_RiskyDownloads(t *testing.T) {
+	var lastRange string
+	exe := append([]byte("MZ\x90\x00"), make([]byte, 64*1024)...)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/setup.exe", func(w http.ResponseWriter, r *http.Request) {
+		lastRange = r.Header.Get("Range")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Range", "bytes 0-4095/65540")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(exe[:4096])
+	})
+	mux.HandleFunc("/landing.exe", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("<html><he"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Download page</title></head></html>"))
+	})
+	mux.HandleFunc("/go", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "javascript:alert(1)", http.StatusFound)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	r := NewOpenGraphResolver()
+	r.SetEgressPolicy(transport.LocalPolicy())
+
+	u, _ := url.Parse(ts.URL + "/setup.exe")
+	res, err := r.Resolve(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lastRange != "bytes=0-4095" {
+		t.Errorf("Expected a small ranged GET, got Range %q", lastRange)
+	}
+	if res.Risk != RiskExecutable || res.ContentLength != 65540 || res.Title != "setup.exe" {
+		t.Errorf("Expected executable with full size, got %+v", res)
+	}
+
+	u, _ = url.Parse(ts.URL + "/landing.exe")
+	res, err = r.Resolve(context.Background(), u)
+	if err != nil || res.Title != "Download page" || res.Risk != "" {
+		t.Errorf("Expected HTML landing page to be read in full, got %+v (%v)", res, err)
+	}
+
+	// A redirect to a javascript: URI is reported, not followed
+	manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+	manager.SetEgressPolicy(transport.LocalPolicy())
+	manager.Register(r)
+	unshortener := NewUnshortenerResolver(manager)
+	manager.SetExpander(unshortener)
+	res = manager.ResolveMulti(context.Background(), []string{ts.URL + "/go"})[ts.URL+"/go"]
+	if res == nil || res.Risk != RiskScriptURI || len(res.RedirectChain) != 2 {
+		t.Errorf("Expected script-uri risk at the end of the chain, got %+v", res)
+	}
+}
@@ -0,0 +1,350 @@
+package resolvers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
+	"golang.org/x/sync/singleflight"
+)
+
+// robotsAgent is the product token from our User-Agent that robots.txt groups are matched against
+const robotsAgent = "youtube-url-replacer"
+
+// maxRobotsBytes is how much of a robots.txt is parsed; RFC 9309 requires at least 500 KiB
+const maxRobotsBytes = 500 * 1024
+
+// ErrRobotsDisallowed is returned when robots.txt forbids fetching a URL
+var ErrRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// ErrCrawlDelay is returned when an origin's Crawl-delay leaves no time to fetch before the deadline
+var ErrCrawlDelay = errors.New("crawl-delay leaves no time before the deadline")
+
+// Robots fetches, parses and caches robots.txt per origin, following RFC 9309:
+// the most specific group for our product token applies, falling back to "*";
+// the longest matching rule wins, with Allow winning ties. A missing robots.txt
+// (4xx) allows everything; an unreachable one (5xx or network error) is
+// treated as disallowing everything until ErrorTTL passes.
+type Robots struct {
+	client *http.Client
+	// Agent is the product token matched against User-agent lines
+	Agent string
+	// TTL is how long a fetched robots.txt is trusted
+	TTL time.Duration
+	// ErrorTTL is how long an unreachable robots.txt blocks the origin before retrying
+	ErrorTTL time.Duration
+	// MaxCrawlDelay caps the Crawl-delay honoured, so one site can't stall us indefinitely
+	MaxCrawlDelay time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+	next    map[string]time.Time // earliest start of the next fetch per origin, for Crawl-delay
+	group   singleflight.Group
+}
+
+type robotsEntry struct {
+	group   *robotsGroup
+	expires time.Time
+}
+
+// maxRobotsEntries bounds the cache; expired entries are dropped first
+const maxRobotsEntries = 10000
+
+func NewRobots() *Robots {
+	return &Robots{
+		client:        SafeHttpClient(2*time.Second, transport.DefaultPolicy()),
+		Agent:         robotsAgent,
+		TTL:           24 * time.Hour,
+		ErrorTTL:      10 * time.Minute,
+		MaxCrawlDelay: 10 * time.Second,
+		entries:       make(map[string]*robotsEntry),
+		next:          make(map[string]time.Time),
+	}
+}
+
+// SetEgressPolicy replaces the client's egress policy
+func (r *Robots) SetEgressPolicy(p *transport.Policy) {
+	r.client = SafeHttpClient(r.client.Timeout, p)
+}
+
+// Check returns ErrRobotsDisallowed if robots.txt forbids u, and otherwise
+// waits out the origin's Crawl-delay, returning ErrCrawlDelay if that would
+// run past the context's deadline
+func (r *Robots) Check(ctx context.Context, u *url.URL) error {
+	g, err := r.lookup(ctx, u)
+	if err != nil {
+		return err
+	}
+	if !g.allowed(robotsPath(u)) {
+		return ErrRobotsDisallowed
+	}
+	return r.wait(ctx, robotsOrigin(u), min(g.crawlDelay, r.MaxCrawlDelay))
+}
+
+// wait reserves the origin's next fetch slot and sleeps until it
+func (r *Robots) wait(ctx context.Context, origin string, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	now := time.Now()
+	r.mu.Lock()
+	start := r.next[origin]
+	if start.Before(now) {
+		start = now
+	}
+	if deadline, ok := ctx.Deadline(); ok && start.After(deadline) {
+		r.mu.Unlock()
+		return ErrCrawlDelay
+	}
+	r.next[origin] = start.Add(delay)
+	r.mu.Unlock()
+
+	if wait := start.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// lookup returns the group that applies to us, fetching robots.txt if it isn't cached
+func (r *Robots) lookup(ctx context.Context, u *url.URL) (*robotsGroup, error) {
+	origin := robotsOrigin(u)
+	r.mu.Lock()
+	entry, ok := r.entries[origin]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.group, nil
+	}
+
+	ch := r.group.DoChan(origin, func() (any, error) {
+		// One caller giving up must not fail the others waiting on this fetch;
+		// the client's timeout still bounds it
+		g, ttl, err := r.fetch(context.WithoutCancel(ctx), origin)
+		if err != nil {
+			return nil, err
+		}
+		r.store(origin, &robotsEntry{group: g, expires: time.Now().Add(ttl)})
+		return g, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*robotsGroup), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *Robots) store(origin string, entry *robotsEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) >= maxRobotsEntries {
+		now := time.Now()
+		for o, e := range r.entries {
+			if now.After(e.expires) {
+				delete(r.entries, o)
+				delete(r.next, o)
+			}
+		}
+		if len(r.entries) >= maxRobotsEntries {
+			r.entries = make(map[string]*robotsEntry)
+		}
+	}
+	r.entries[origin] = entry
+}
+
+// fetch downloads robots.txt and decides what it means for us. Errors are
+// only returned when the fetch was cut short, by its context or by timing
+// out, or refused by the fetch guard, so nothing gets cached for a slow answer.
+func (r *Robots) fetch(ctx context.Context, origin string) (*robotsGroup, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if ctx.Err() != nil || errors.Is(err, ErrBlockedHost) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil, 0, err
+		}
+		return disallowAll, r.ErrorTTL, nil
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return disallowAll, r.ErrorTTL, nil
+	case resp.StatusCode >= 400:
+		return allowAll, r.TTL, nil
+	case resp.StatusCode >= 300:
+		// The client already followed redirects; more than it allows counts as unavailable
+		return allowAll, r.TTL, nil
+	}
+	return parseRobots(io.LimitReader(resp.Body, maxRobotsBytes), r.Agent), r.TTL, nil
+}
+
+// robotsOrigin is the scheme and host robots.txt is fetched from
+func robotsOrigin(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// robotsPath is the part of the URL rules are matched against
+func robotsPath(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p
+}
+
+type robotsRule struct {
+	allow   bool
+	length  int // Specificity: the length of the pattern as written
+	pattern *regexp.Regexp
+}
+
+// robotsGroup holds the rules of the group that applies to us
+type robotsGroup struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+var (
+	allowAll    = &robotsGroup{}
+	disallowAll = &robotsGroup{rules: []robotsRule{{allow: false, length: 1, pattern: regexp.MustCompile(`^/`)}}}
+)
+
+// allowed applies the longest matching rule; /robots.txt itself is always allowed
+func (g *robotsGroup) allowed(path string) bool {
+	if path == "/robots.txt" {
+		return true
+	}
+	allow, best := true, -1
+	for _, rule := range g.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > best || (rule.length == best && rule.allow) {
+			allow, best = rule.allow, rule.length
+		}
+	}
+	return allow
+}
+
+// compileRobotsPattern turns a path pattern with "*" wildcards and a "$" end anchor into a regexp
+func compileRobotsPattern(p string) *regexp.Regexp {
+	anchored := strings.HasSuffix(p, "$")
+	p = strings.TrimSuffix(p, "$")
+	parts := strings.Split(p, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// parseRobots reads a robots.txt and returns the group for agent: every group
+// naming it, merged, or else every "*" group, or else nothing
+func parseRobots(r io.Reader, agent string) *robotsGroup {
+	agent = strings.ToLower(agent)
+	groups := make(map[string]*robotsGroup)
+	var current []string
+	inRules := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRobotsBytes)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive User-agent lines share the rules that follow them
+			if inRules {
+				current, inRules = nil, false
+			}
+			name, _, _ := strings.Cut(strings.ToLower(value), "/")
+			current = append(current, name)
+			if groups[name] == nil {
+				groups[name] = &robotsGroup{}
+			}
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue // An empty Disallow allows everything, which is the default anyway
+			}
+			if !strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "*") {
+				value = "/" + value
+			}
+			rule := robotsRule{allow: key == "allow", length: len(value), pattern: compileRobotsPattern(value)}
+			for _, name := range current {
+				groups[name].rules = append(groups[name].rules, rule)
+			}
+		case "crawl-delay":
+			inRules = true
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(seconds >= 0) { // Also rejects NaN
+				continue
+			}
+			// Huge delays would overflow the Duration into a negative one
+			seconds = min(seconds, float64(math.MaxInt64/int64(time.Second)))
+			for _, name := range current {
+				groups[name].crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+
+	if g, ok := groups[agent]; ok {
+		return g
+	}
+	if g, ok := groups["*"]; ok {
+		return g
+	}
+	return allowAll
+}
+
+// robotsResult describes a page robots.txt won't let us fetch, from its URL alone
+func robotsResult(u *url.URL) *Result {
+	return &Result{
+		Title:       strings.TrimPrefix(u.Hostname(), "www."),
+		Description: fmt.Sprintf("Not fetched: the robots.txt of %s asks crawlers to stay away or slow down", u.Hostname()),
+		Platform:    "Generic",
+		FinalURL:    u.String(),
+	}
+}
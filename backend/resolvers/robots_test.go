@@ -0,0 +1,298 @@
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
+)
+
+func TestParseRobots(t *testing.T) {
+	robots := `
+# Comments and unknown lines are ignored
+Sitemap: https://example.com/sitemap.xml
+
+User-agent: *
+Disallow: /private/
+Allow: /private/public-*.html$
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: Googlebot
+User-Agent: youtube-url-replacer/1.0
+Disallow: /search
+Allow: /search/about
+Disallow: /p
+Allow: /p
+
+User-agent: otherbot
+Disallow: /
+`
+	ours := parseRobots(strings.NewReader(robots), robotsAgent)
+	star := parseRobots(strings.NewReader(robots), "somebot")
+	other := parseRobots(strings.NewReader(robots), "otherbot")
+
+	tests := []struct {
+		group *robotsGroup
+		path  string
+		want  bool
+	}{
+		{ours, "/", true},
+		{ours, "/search", false},
+		{ours, "/search?q=x", false},
+		{ours, "/search/about", true},
+		{ours, "/private/", true}, // Our group replaces the "*" group entirely
+		{ours, "/p", true},        // Allow wins a tie
+		{ours, "/robots.txt", true},
+		{star, "/private/x", false},
+		{star, "/private/public-1.html", true},
+		{star, "/private/public-1.html?x", false}, // "$" anchors the end
+		{star, "/docs/a.pdf", false},
+		{star, "/docs/a.pdf.html", true},
+		{star, "/search", true},
+		{other, "/anything", false},
+		{other, "/robots.txt", true},
+	}
+	for _, tc := range tests {
+		if got := tc.group.allowed(tc.path); got != tc.want {
+			t.Errorf("allowed(%q) = %v; want %v", tc.path, got, tc.want)
+		}
+	}
+
+	if star.crawlDelay != 2*time.Second || ours.crawlDelay != 0 {
+		t.Errorf("Unexpected crawl delays: * %v, ours %v", star.crawlDelay, ours.crawlDelay)
+	}
+	for _, delay := range []string{"1e12", "Inf"} {
+		if g := parseRobots(strings.NewReader("User-agent: *\nCrawl-delay: "+delay), robotsAgent); g.crawlDelay < 24*time.Hour {
+			t.Errorf("Crawl-delay %s parsed as %v; want a very long delay", delay, g.crawlDelay)
+		}
+	}
+	if g := parseRobots(strings.NewReader("User-agent: *\nCrawl-delay: NaN"), robotsAgent); g.crawlDelay != 0 {
+		t.Errorf("Expected NaN Crawl-delay to be ignored, got %v", g.crawlDelay)
+	}
+	if g := parseRobots(strings.NewReader("User-agent: x\nDisallow:\n"), robotsAgent); g != allowAll {
+		t.Errorf("Expected allow-all without a matching group, got %+v", g)
+	}
+}
+
+func newRobotsServer(t *testing.T, status int, body string) (*httptest.Server, *atomic.Int32) {
+	var fetches atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fetches.Add(1)
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Page " + r.URL.Path + "</title></head></html>"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &fetches
+}
+
+func TestRobots_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		path    string
+		wantErr error
+	}{
+		{"allowed", http.StatusOK, "User-agent: *\nDisallow: /admin", "/page", nil},
+		{"disallowed", http.StatusOK, "User-agent: *\nDisallow: /admin", "/admin/x", ErrRobotsDisallowed},
+		{"missing", http.StatusNotFound, "", "/admin/x", nil},
+		{"forbidden", http.StatusForbidden, "", "/page", nil},
+		{"unavailable", http.StatusServiceUnavailable, "", "/page", ErrRobotsDisallowed},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, _ := newRobotsServer(t, tc.status, tc.body)
+			robots := NewRobots()
+			robots.SetEgressPolicy(transport.LocalPolicy())
+
+			u, _ := url.Parse(ts.URL + tc.path)
+			if err := robots.Check(context.Background(), u); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Check(%s) = %v; want %v", tc.path, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRobots_Caches(t *testing.T) {
+	ts, fetches := newRobotsServer(t, http.StatusOK, "User-agent: *\nDisallow: /admin")
+	robots := NewRobots()
+	robots.SetEgressPolicy(transport.LocalPolicy())
+
+	for _, p := range []string{"/a", "/b", "/admin", "/c"} {
+		u, _ := url.Parse(ts.URL + p)
+		robots.Check(context.Background(), u)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("Expected robots.txt to be fetched once, got %d", fetches.Load())
+	}
+
+	// Expired entries are fetched again
+	for origin := range robots.entries {
+		robots.entries[origin].expires = time.Now().Add(-time.Second)
+	}
+	u, _ := url.Parse(ts.URL + "/d")
+	robots.Check(context.Background(), u)
+	if fetches.Load() != 2 {
+		t.Errorf("Expected an expired robots.txt to be refetched, got %d fetches", fetches.Load())
+	}
+}
+
+func TestRobots_SlowFetch(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		if _, err := w.Write([]byte("User-agent: *\nDisallow: /admin")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	robots := NewRobots()
+	robots.SetEgressPolicy(transport.LocalPolicy())
+	robots.client.Timeout = 100 * time.Millisecond
+	u, _ := url.Parse(ts.URL + "/page")
+
+	// A timeout isn't an unreachable robots.txt: nothing is cached and the next check fetches again
+	if err := robots.Check(context.Background(), u); err == nil || errors.Is(err, ErrRobotsDisallowed) {
+		t.Fatalf("Expected the timeout itself, got %v", err)
+	}
+	if err := robots.Check(context.Background(), u); err != nil {
+		t.Errorf("Expected the second fetch to allow the page, got %v", err)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("Expected 2 fetches, got %d", fetches.Load())
+	}
+}
+
+func TestRobots_SharedFetchOutlivesCaller(t *testing.T) {
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		if _, err := w.Write([]byte("User-agent: *\nDisallow: /admin")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	robots := NewRobots()
+	robots.SetEgressPolicy(transport.LocalPolicy())
+	u, _ := url.Parse(ts.URL + "/page")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() { first <- robots.Check(ctx, u) }()
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error, 1)
+	go func() { second <- robots.Check(context.Background(), u) }()
+
+	// The first caller gives up while the fetch it started is still running
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the canceled caller to get its own error, got %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("Expected the waiting caller to get the fetched robots.txt, got %v", err)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("Expected one shared fetch, got %d", fetches.Load())
+	}
+}
+
+func TestRobots_CrawlDelay(t *testing.T) {
+	ts, _ := newRobotsServer(t, http.StatusOK, "User-agent: *\nCrawl-delay: 0.2")
+	robots := NewRobots()
+	robots.SetEgressPolicy(transport.LocalPolicy())
+	u, _ := url.Parse(ts.URL + "/page")
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := robots.Check(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("Expected fetches to be spaced by the crawl delay, took %v", elapsed)
+	}
+
+	// A caller that can't wait for its turn is told so straight away
+	robots.Check(context.Background(), u)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := robots.Check(ctx, u); !errors.Is(err, ErrCrawlDelay) {
+		t.Errorf("Expected ErrCrawlDelay, got %v", err)
+	}
+
+	// Delays are capped
+	robots.MaxCrawlDelay = 0
+	start = time.Now()
+	robots.Check(context.Background(), u)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("Expected MaxCrawlDelay to cap the wait")
+	}
+}
+
+func TestOpenGraphResolver_Robots(t *testing.T) {
+	var pageFetches atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: youtube-url-replacer\nDisallow: /members"))
+			return
+		}
+		pageFetches.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Members area</title></head></html>"))
+	}))
+	defer ts.Close()
+
+	r := NewOpenGraphResolver()
+	r.SetEgressPolicy(transport.LocalPolicy())
+
+	u, _ := url.Parse(ts.URL + "/members/profile")
+	res, err := r.Resolve(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Expected a domain-only result, got %v", err)
+	}
+	if pageFetches.Load() != 0 {
+		t.Error("A disallowed page was fetched")
+	}
+	if res.Title != u.Hostname() || !strings.Contains(res.Description, "robots.txt") || res.FinalURL != u.String() {
+		t.Errorf("Unexpected domain-only result %+v", res)
+	}
+
+	u, _ = url.Parse(ts.URL + "/news")
+	if res, err = r.Resolve(context.Background(), u); err != nil || res.Title != "Members area" {
+		t.Errorf("Expected allowed pages to be fetched, got %+v (%v)", res, err)
+	}
+
+	// Self-hosters can switch it off
+	r.SetRobots(nil)
+	u, _ = url.Parse(ts.URL + "/members/profile")
+	if res, err = r.Resolve(context.Background(), u); err != nil || res.Title != "Members area" {
+		t.Errorf("Expected the page to be fetched with robots.txt ignored, got %+v (%v)", res, err)
+	}
+}
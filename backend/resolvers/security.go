@@ -0,0 +1,73 @@
+package resolvers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// newCertificateAge is how young a certificate must be to count as newly issued
+const newCertificateAge = 7 * 24 * time.Hour
+
+// now is replaced in tests
+var now = time.Now
+
+// describeTLS captures what the handshake told us about the server; nil for plain http
+func describeTLS(state *tls.ConnectionState) *Security {
+	if state == nil {
+		return nil
+	}
+	sec := &Security{HTTPS: true, TLSVersion: tls.VersionName(state.Version)}
+	if len(state.PeerCertificates) > 0 {
+		sec.Certificate = describeCertificate(state.PeerCertificates[0])
+	}
+	return sec
+}
+
+func describeCertificate(cert *x509.Certificate) *Certificate {
+	return &Certificate{
+		Subject:     certName(cert.Subject.CommonName, cert.Subject.Organization),
+		Issuer:      certName(cert.Issuer.CommonName, cert.Issuer.Organization),
+		DNSNames:    cert.DNSNames,
+		NotBefore:   cert.NotBefore.UTC(),
+		NotAfter:    cert.NotAfter.UTC(),
+		NewlyIssued: now().Sub(cert.NotBefore) < newCertificateAge,
+	}
+}
+
+// certName prefers "CN (Org)" and falls back to whichever part exists
+func certName(cn string, org []string) string {
+	o := strings.Join(org, ", ")
+	switch {
+	case cn != "" && o != "":
+		return cn + " (" + o + ")"
+	case cn != "":
+		return cn
+	}
+	return o
+}
+
+// applySecurity completes the security section from the destination and the
+// chain. Resolvers that fetched the page themselves have already filled in
+// the certificate; the rest only get the scheme and downgrade flags.
+func applySecurity(res *Result, chain []Hop, start, final *url.URL) {
+	if final.Scheme != "http" && final.Scheme != "https" {
+		return
+	}
+	sec := res.Security
+	if sec == nil {
+		sec = &Security{HTTPS: final.Scheme == "https"}
+	}
+	for _, hop := range chain {
+		if hop.Downgrade {
+			sec.Downgrade = true
+		}
+	}
+	// Resolvers may have been redirected to http on their own, outside the chain
+	if start.Scheme == "https" && final.Scheme == "http" {
+		sec.Downgrade = true
+	}
+	res.Security = sec
+}
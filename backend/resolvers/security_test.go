@@ -0,0 +1,93 @@
+package resolvers
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDescribeCertificate(t *testing.T) {
+	fixed := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	cert := &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "login.example.net"},
+		Issuer:    pkix.Name{CommonName: "R3", Organization: []string{"Let's Encrypt"}},
+		DNSNames:  []string{"login.example.net"},
+		NotBefore: fixed.Add(-2 * 24 * time.Hour),
+		NotAfter:  fixed.Add(88 * 24 * time.Hour),
+	}
+	c := describeCertificate(cert)
+	if c.Subject != "login.example.net" || c.Issuer != "R3 (Let's Encrypt)" || !c.NewlyIssued {
+		t.Errorf("Unexpected certificate summary %+v", c)
+	}
+
+	cert.NotBefore = fixed.Add(-60 * 24 * time.Hour)
+	if describeCertificate(cert).NewlyIssued {
+		t.Error("Expected a 60 day old certificate not to be newly issued")
+	}
+}
+
+func TestApplySecurity(t *testing.T) {
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+
+	res := &Result{}
+	applySecurity(res, nil, parse("https://example.com/"), parse("https://example.com/"))
+	if res.Security == nil || !res.Security.HTTPS || res.Security.Downgrade {
+		t.Errorf("Expected plain https posture, got %+v", res.Security)
+	}
+
+	res = &Result{}
+	chain := []Hop{{URL: "https://short.example/x"}, {URL: "http://dest.example/", Downgrade: true}}
+	applySecurity(res, chain, parse("https://short.example/x"), parse("http://dest.example/"))
+	if res.Security.HTTPS || !res.Security.Downgrade {
+		t.Errorf("Expected downgrade from the chain, got %+v", res.Security)
+	}
+
+	// A resolver following its own redirects to http still counts
+	res = &Result{Security: &Security{HTTPS: false}}
+	applySecurity(res, nil, parse("https://example.com/"), parse("http://example.com/"))
+	if !res.Security.Downgrade {
+		t.Errorf("Expected downgrade between start and final URL, got %+v", res.Security)
+	}
+
+	res = &Result{}
+	applySecurity(res, nil, parse("data:,x"), parse("data:,x"))
+	if res.Security != nil {
+		t.Errorf("Expected no security section for inline URIs, got %+v", res.Security)
+	}
+}
+
+func TestOpenGraphResolver_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Secure page</title></head></html>"))
+	}))
+	defer ts.Close()
+
+	r := NewOpenGraphResolver()
+	r.client = ts.Client()
+	r.robots.client = ts.Client()
+
+	u, _ := url.Parse(ts.URL)
+	res, err := r.Resolve(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sec := res.Security
+	if sec == nil || !sec.HTTPS || sec.TLSVersion == "" || sec.Certificate == nil {
+		t.Fatalf("Expected TLS details, got %+v", sec)
+	}
+	if sec.Certificate.Issuer == "" || sec.Certificate.NotAfter.IsZero() {
+		t.Errorf("Expected certificate details, got %+v", sec.Certificate)
+	}
+}
@@ -2,18 +2,23 @@ package resolvers
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
 )
 
-// UnshortenerResolver follows redirect chains to find the final URL
+// UnshortenerResolver follows redirect chains to find the final URL.
+// It is normally installed as the manager's Expander so every URL gets its
+// redirects followed; the shortener domain list is only a hint that a URL
+// must be expanded even when another resolver claims it.
 type UnshortenerResolver struct {
 	client  *http.Client
 	manager *ResolverManager
 	domains []string
+	maxHops int
 }
 
 // NewUnshortenerResolver creates a new resolver for shortened URLs
@@ -24,18 +29,50 @@ func NewUnshortenerResolver(manager *ResolverManager) *UnshortenerResolver {
 		"goo.gl", "bit.do", "ow.ly", "t.ly", "shorturl.at",
 	}
 
+	// The unshortener belongs to its manager and starts out with its egress policy
+	policy := manager.egress
+	if policy == nil {
+		policy = transport.DefaultPolicy()
+	}
+
 	return &UnshortenerResolver{
-		client:  SafeHttpClient(2 * time.Second),
+		client:  SafeHttpClient(2*time.Second, policy),
 		manager: manager,
 		domains: domains,
+		maxHops: 5,
+	}
+}
+
+// AddShortenerDomains extends the list of hosts that are always expanded
+func (r *UnshortenerResolver) AddShortenerDomains(domains ...string) {
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "www.")
+		if d != "" {
+			r.domains = append(r.domains, d)
+		}
 	}
 }
 
+// SetMaxHops sets the redirect hop budget
+func (r *UnshortenerResolver) SetMaxHops(n int) {
+	r.maxHops = n
+}
+
 func (r *UnshortenerResolver) Name() string {
 	return "unshortener"
 }
 
+// SetEgressPolicy replaces the client's egress policy
+func (r *UnshortenerResolver) SetEgressPolicy(p *transport.Policy) {
+	r.client = SafeHttpClient(r.client.Timeout, p)
+}
+
 func (r *UnshortenerResolver) CanHandle(u *url.URL) bool {
+	return r.IsShortener(u)
+}
+
+// IsShortener reports whether the URL is on a known shortener domain
+func (r *UnshortenerResolver) IsShortener(u *url.URL) bool {
 	host := strings.ToLower(u.Host)
 	host = strings.TrimPrefix(host, "www.")
 
@@ -47,52 +84,167 @@ func (r *UnshortenerResolver) CanHandle(u *url.URL) bool {
 	return false
 }
 
-func (r *UnshortenerResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
-	currentURL := u.String()
-	hops := 0
-	maxHops := 5
-	seen := make(map[string]bool)
+// Expand walks the redirect chain from u and returns the final URL along with
+// every hop visited. It stops early when a hop lands on a URL a specialized
+// resolver can handle directly.
+//
+// Each hop is probed with HEAD first. When HEAD is refused (405, 403, 501...)
+// or inconclusive (a redirect without Location, or a shortener answering 200),
+// the hop is retried with a GET whose body is discarded. 307/308 responses
+// require the next hop to reuse the same method, so a GET fallback sticks
+// across them; 301/302/303 let the next hop start with HEAD again.
+func (r *UnshortenerResolver) Expand(ctx context.Context, u *url.URL) (*url.URL, []Hop, error) {
+	startURL := u.String()
+	currentURL := startURL
+	tracker := newRedirectTracker(r.maxHops)
+	method := http.MethodHead
+	var chain []Hop
 
-	for hops < maxHops {
-		if seen[currentURL] {
-			return nil, fmt.Errorf("redirect loop detected at %s", currentURL)
+	// record appends the hop for the current URL to the chain
+	record := func(method string, status int) {
+		var prev *Hop
+		if len(chain) > 0 {
+			prev = &chain[len(chain)-1]
 		}
-		seen[currentURL] = true
+		hop := newHop(u, status, prev)
+		hop.Method = method
+		chain = append(chain, hop)
+	}
 
-		req, err := http.NewRequestWithContext(ctx, "HEAD", currentURL, nil)
-		if err != nil {
-			return nil, err
+	for {
+		if err := tracker.visit(currentURL); err != nil {
+			return nil, chain, err
 		}
-		req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
 
-		// We use a client that DOES NOT automatically follow redirects so we can track them
-		resp, err := r.client.Transport.RoundTrip(req)
-		if err != nil {
-			return nil, err
+		// Wrappers met mid-chain are decoded offline rather than requested
+		if r.manager.unwrapper != nil {
+			if inner, ok := r.manager.unwrapper.unwrapOnce(u); ok {
+				var prev *Hop
+				if len(chain) > 0 {
+					prev = &chain[len(chain)-1]
+				}
+				hop := newHop(u, 0, prev)
+				hop.Unwrapped = true
+				chain = append(chain, hop)
+				method = http.MethodHead
+				currentURL = inner.String()
+				u = inner
+				if !tracker.next() {
+					// Hop budget exhausted; the wrapper's destination is where we are, unfetched
+					record("", 0)
+					break
+				}
+				continue
+			}
 		}
-		resp.Body.Close()
 
-		if resp.StatusCode >= 300 && resp.StatusCode < 400 {
-			location := resp.Header.Get("Location")
-			if location == "" {
-				break // Redirect without location? Stop here.
-			}
+		// No need to ask youtube.com where it goes; the YouTube resolver knows
+		if currentURL != startURL && r.manager.hasSpecialist(u) && !r.IsShortener(u) {
+			record("", 0)
+			break
+		}
+
+		// Known-malicious hosts are recorded but never contacted
+		if r.manager.isBlocked(u) {
+			record("", 0)
+			break
+		}
 
-			// Handle relative URLs
-			nextURL, err := u.Parse(location)
-			if err != nil {
-				break
+		resp, err := r.probe(ctx, method, currentURL)
+		if err != nil {
+			return nil, chain, err
+		}
+		if method == http.MethodHead && r.headInconclusive(u, resp) {
+			method = http.MethodGet
+			if resp, err = r.probe(ctx, method, currentURL); err != nil {
+				return nil, chain, err
 			}
-			currentURL = nextURL.String()
-			u = nextURL // Update u for relative parsing in next hop
-			hops++
-		} else {
+		}
+		record(method, resp.StatusCode)
+
+		if !isFollowableRedirect(resp.StatusCode) {
 			// Not a redirect, we've reached the end
 			break
 		}
+		location := resp.Header.Get("Location")
+		if location == "" {
+			break // Redirect without location? Stop here.
+		}
+
+		// Handle relative URLs
+		nextURL, err := u.Parse(location)
+		if err != nil {
+			break
+		}
+		// A Location without a fragment inherits the current one (RFC 9110 §10.2.2)
+		if nextURL.Fragment == "" {
+			nextURL.Fragment = u.Fragment
+		}
+		if !tracker.next() {
+			break // Hop budget exhausted; resolve where we are
+		}
+		// javascript: and data: targets can't be requested; they are the destination
+		if isInlineScheme(nextURL) {
+			u = nextURL
+			record("", 0)
+			break
+		}
+
+		// 307/308 forbid changing the method; the others let us try HEAD again
+		if resp.StatusCode != http.StatusTemporaryRedirect && resp.StatusCode != http.StatusPermanentRedirect {
+			method = http.MethodHead
+		}
+		currentURL = nextURL.String()
+		u = nextURL // Update u for relative parsing in next hop
+	}
+
+	return u, chain, nil
+}
+
+// probe issues a single request without following redirects. GET bodies are
+// discarded unread; we only need the status line and headers.
+func (r *UnshortenerResolver) probe(ctx context.Context, method, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("User-Agent", "youtube-url-replacer/1.0 (+https://github.com/shaunhickson/youtube-url-replacer)")
 
-	finalURL, err := url.Parse(currentURL)
+	// We use a client that DOES NOT automatically follow redirects so we can track them
+	resp, err := r.client.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// headInconclusive reports whether a HEAD response can't be trusted to describe the hop
+func (r *UnshortenerResolver) headInconclusive(u *url.URL, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusForbidden, http.StatusNotImplemented,
+		http.StatusBadRequest, http.StatusNotFound:
+		return true
+	}
+	if isFollowableRedirect(resp.StatusCode) && resp.Header.Get("Location") == "" {
+		return true
+	}
+	// A shortener that answers HEAD with a page rather than a redirect usually only redirects on GET
+	return resp.StatusCode >= 200 && resp.StatusCode < 300 && r.IsShortener(u)
+}
+
+// isFollowableRedirect excludes 304 Not Modified and the deprecated 305 Use Proxy
+func isFollowableRedirect(status int) bool {
+	switch status {
+	case http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+func (r *UnshortenerResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
+	finalURL, chain, err := r.Expand(ctx, u)
 	if err != nil {
 		return nil, err
 	}
@@ -101,5 +253,13 @@ func (r *UnshortenerResolver) Resolve(ctx context.Context, u *url.URL) (*Result,
 	// This allows it to hit YouTube, OpenGraph, etc.
 	// We call Resolve on the manager but we must be careful of recursion
 	// The manager already has a list of resolvers.
-	return r.manager.resolveRecursively(ctx, finalURL, r.Name())
+	res, err := r.manager.resolveRecursively(ctx, finalURL, r.Name())
+	if err != nil {
+		return nil, err
+	}
+	if res.FinalURL == "" {
+		res.FinalURL = finalURL.String()
+	}
+	res.RedirectChain = extendChain(chain, res.RedirectChain)
+	return res, nil
 }
@@ -5,15 +5,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/sph/youtube-url-replacer/backend/transport"
 )
 
 func TestUnshortenerResolver(t *testing.T) {
-	transport.AllowLocalIPs = true
-	defer func() { transport.AllowLocalIPs = false }()
-
 	mux := http.NewServeMux()
 
 	// 1. Simple redirect
@@ -43,8 +43,9 @@ func TestUnshortenerResolver(t *testing.T) {
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	cache := &MockCache{store: make(map[string]string)}
+	cache := &MockCache{store: make(map[string]*Result)}
 	manager := NewResolverManager(cache)
+	manager.SetEgressPolicy(transport.LocalPolicy())
 	
 	unshortener := NewUnshortenerResolver(manager)
 	// Override domains for testing
@@ -52,6 +53,7 @@ func TestUnshortenerResolver(t *testing.T) {
 	unshortener.domains = []string{u.Host}
 
 	og := NewOpenGraphResolver()
+	og.SetEgressPolicy(transport.LocalPolicy())
 	yt, _ := NewYouTubeResolver("") // Mock YT
 
 	manager.Register(yt)
@@ -69,6 +71,9 @@ func TestUnshortenerResolver(t *testing.T) {
 		if res.Title != "Final Destination" {
 			t.Errorf("Expected 'Final Destination', got '%s'", res.Title)
 		}
+		if res.FinalURL != ts.URL+"/final" {
+			t.Errorf("Expected final URL %s/final, got '%s'", ts.URL, res.FinalURL)
+		}
 	})
 
 	t.Run("Redirect Loop", func(t *testing.T) {
@@ -91,3 +96,242 @@ func TestUnshortenerResolver(t *testing.T) {
 		}
 	})
 }
+
+func TestUnshortenerResolver_AsExpander(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/vanity", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/tracker", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/tracker", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://youtu.be/dQw4w9WgXcQ", http.StatusFound)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if _, err := w.Write([]byte("<html><head><title>Article</title></head></html>")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article", http.StatusMovedPermanently)
+	})
+
+	// Not on the shortener list: redirects are followed anyway
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cache := &MockCache{store: make(map[string]*Result)}
+	manager := NewResolverManager(cache)
+	manager.SetEgressPolicy(transport.LocalPolicy())
+	yt, _ := NewYouTubeResolver("")
+	manager.Register(yt)
+	manager.Register(NewOpenGraphResolver())
+	manager.SetExpander(NewUnshortenerResolver(manager))
+
+	ctx := context.Background()
+	results := manager.ResolveMulti(ctx, []string{ts.URL + "/vanity", ts.URL + "/moved"})
+
+	if res := results[ts.URL+"/vanity"]; res == nil || res.Title != "Mock Title for Video dQw4w9WgXcQ" {
+		t.Errorf("Expected vanity link to reach the YouTube resolver, got %+v", res)
+	} else {
+		if res.FinalURL != "https://youtu.be/dQw4w9WgXcQ" {
+			t.Errorf("Expected final URL of the YouTube video, got %q", res.FinalURL)
+		}
+		want := []Hop{
+			{URL: ts.URL + "/vanity", Method: "HEAD", Status: http.StatusMovedPermanently},
+			{URL: ts.URL + "/tracker", Method: "HEAD", Status: http.StatusFound},
+			{URL: "https://youtu.be/dQw4w9WgXcQ", Status: 0, CrossDomain: true},
+		}
+		if !reflect.DeepEqual(res.RedirectChain, want) {
+			t.Errorf("Unexpected redirect chain:\n got %+v\nwant %+v", res.RedirectChain, want)
+		}
+	}
+
+	if res := results[ts.URL+"/moved"]; res == nil || res.Title != "Article" {
+		t.Errorf("Expected moved link to resolve to Article, got %+v", res)
+	}
+}
+
+func TestUnshortenerResolver_WrapperPastHopBudget(t *testing.T) {
+	var wrapperHits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/wrap?u=https%3A%2F%2Fexample.com%2Fdest", http.StatusFound)
+	})
+	mux.HandleFunc("/wrap", func(w http.ResponseWriter, r *http.Request) {
+		wrapperHits.Add(1)
+		http.Redirect(w, r, r.URL.Query().Get("u"), http.StatusFound)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+	manager.SetEgressPolicy(transport.LocalPolicy())
+	manager.unwrapper = &Unwrapper{rules: []unwrapRule{{name: "test", host: hostIs("127.0.0.1"), path: "/wrap", params: []string{"u"}}}}
+	unshortener := NewUnshortenerResolver(manager)
+	unshortener.SetMaxHops(1)
+
+	u, _ := url.Parse(ts.URL + "/short")
+	final, chain, err := unshortener.Expand(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if wrapperHits.Load() != 0 {
+		t.Error("Expected the wrapper not to be contacted once the hop budget ran out")
+	}
+	if final.String() != "https://example.com/dest" {
+		t.Errorf("Expected the wrapped destination, got %s", final)
+	}
+	want := []Hop{
+		{URL: ts.URL + "/short", Method: "HEAD", Status: http.StatusFound},
+		{URL: ts.URL + "/wrap?u=https%3A%2F%2Fexample.com%2Fdest", Status: 0, Unwrapped: true},
+		{URL: "https://example.com/dest", Status: 0, CrossDomain: true},
+	}
+	if !reflect.DeepEqual(chain, want) {
+		t.Errorf("Unexpected redirect chain:\n got %+v\nwant %+v", chain, want)
+	}
+}
+
+func TestNewHop(t *testing.T) {
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+	tests := []struct {
+		prev, next          string
+		crossDomain, downgr bool
+	}{
+		{"https://bit.ly/x", "https://www.example.com/", true, false},
+		{"https://www.example.com/a", "https://blog.example.com/b", false, false},
+		{"https://news.bbc.co.uk/", "https://www.bbc.co.uk/news", false, false},
+		{"https://example.com/", "http://example.com/", false, true},
+		{"http://example.com/", "https://example.com/", false, false},
+	}
+	for _, tc := range tests {
+		prev := newHop(parse(tc.prev), 301, nil)
+		hop := newHop(parse(tc.next), 200, &prev)
+		if hop.CrossDomain != tc.crossDomain || hop.Downgrade != tc.downgr {
+			t.Errorf("%s -> %s: crossDomain=%v downgrade=%v; want %v, %v",
+				tc.prev, tc.next, hop.CrossDomain, hop.Downgrade, tc.crossDomain, tc.downgr)
+		}
+	}
+}
+
+func TestUnshortenerResolver_HeadFallback(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	logRequest := func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, r.Method+" "+r.URL.Path)
+	}
+
+	// The destination lives on a host that isn't a known shortener
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer dest.Close()
+
+	mux := http.NewServeMux()
+	// Shortener that rejects HEAD outright
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.Redirect(w, r, dest.URL+"/dest", http.StatusMovedPermanently)
+	})
+	// CDN that forbids HEAD
+	mux.HandleFunc("/cdn", func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.Redirect(w, r, dest.URL+"/dest", http.StatusFound)
+	})
+	// Shortener that serves an interstitial page to HEAD but redirects on GET
+	mux.HandleFunc("/page-on-head", func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Redirect(w, r, dest.URL+"/dest", http.StatusFound)
+	})
+	// 307 after a GET fallback must keep using GET; HEAD would look like a dead end
+	mux.HandleFunc("/temp", func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.Redirect(w, r, "/strict", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/strict", func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r)
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Redirect(w, r, dest.URL+"/dest", http.StatusPermanentRedirect)
+	})
+	// 303 lets the next hop go back to HEAD
+	mux.HandleFunc("/see-other", func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		http.Redirect(w, r, dest.URL+"/dest", http.StatusSeeOther)
+	})
+	// 305 Use Proxy is never followed
+	mux.HandleFunc("/use-proxy", func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r)
+		w.Header().Set("Location", dest.URL+"/dest")
+		w.WriteHeader(http.StatusUseProxy)
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+	manager.SetEgressPolicy(transport.LocalPolicy())
+	unshortener := NewUnshortenerResolver(manager)
+	host, _ := url.Parse(ts.URL)
+	unshortener.domains = []string{host.Host}
+
+	tests := []struct {
+		path      string
+		wantFinal string
+		wantSeen  []string
+	}{
+		{"/no-head", dest.URL + "/dest", []string{"HEAD /no-head", "GET /no-head", "HEAD /dest"}},
+		{"/cdn", dest.URL + "/dest", []string{"HEAD /cdn", "GET /cdn", "HEAD /dest"}},
+		{"/page-on-head", dest.URL + "/dest", []string{"HEAD /page-on-head", "GET /page-on-head", "HEAD /dest"}},
+		{"/temp", dest.URL + "/dest", []string{"HEAD /temp", "GET /temp", "GET /strict", "GET /dest"}},
+		{"/see-other", dest.URL + "/dest", []string{"HEAD /see-other", "GET /see-other", "HEAD /dest"}},
+		{"/use-proxy", ts.URL + "/use-proxy", []string{"HEAD /use-proxy"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			seen = nil
+			u, _ := url.Parse(ts.URL + tc.path)
+			final, chain, err := unshortener.Expand(context.Background(), u)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if final.String() != tc.wantFinal {
+				t.Errorf("Expected final %s, got %s", tc.wantFinal, final)
+			}
+			if !reflect.DeepEqual(seen, tc.wantSeen) {
+				t.Errorf("Unexpected requests:\n got %v\nwant %v", seen, tc.wantSeen)
+			}
+			if len(chain) == 0 || chain[len(chain)-1].URL != final.String() {
+				t.Errorf("Expected chain to end at the final URL, got %+v", chain)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package resolvers
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// maxUnwrapDepth bounds how many nested wrappers are peeled off one URL
+const maxUnwrapDepth = 5
+
+// unwrapRule recognises one link-wrapper service. The destination is read
+// from the first non-empty query parameter in params, or by extract when set.
+type unwrapRule struct {
+	name    string
+	host    func(host string) bool
+	path    string // Path prefix; empty matches any path
+	params  []string
+	extract func(u *url.URL) string
+}
+
+func hostIs(hosts ...string) func(string) bool {
+	return func(h string) bool {
+		for _, want := range hosts {
+			if h == want {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func hostHasSuffix(suffix string) func(string) bool {
+	return func(h string) bool {
+		return strings.HasSuffix(h, suffix)
+	}
+}
+
+// isGoogleHost matches google.com, www.google.co.uk and friends, but not google.evil.com
+func isGoogleHost(h string) bool {
+	h = strings.TrimPrefix(h, "www.")
+	suffix, _ := publicsuffix.PublicSuffix(h)
+	return h == "google."+suffix
+}
+
+var defaultUnwrapRules = []unwrapRule{
+	{name: "google", host: isGoogleHost, path: "/url", params: []string{"q", "url"}},
+	{name: "facebook", host: hostIs("l.facebook.com", "lm.facebook.com", "l.messenger.com"), path: "/l.php", params: []string{"u"}},
+	{name: "instagram", host: hostIs("l.instagram.com"), params: []string{"u"}},
+	{name: "outlook-safelinks", host: hostHasSuffix(".safelinks.protection.outlook.com"), params: []string{"url"}},
+	{name: "slack", host: hostIs("slack-redir.net"), path: "/link", params: []string{"url"}},
+	{name: "tumblr", host: hostIs("t.umblr.com"), path: "/redirect", params: []string{"z"}},
+	{name: "youtube", host: hostIs("youtube.com", "www.youtube.com", "m.youtube.com"), path: "/redirect", params: []string{"q"}},
+	{name: "vk", host: hostIs("vk.com", "m.vk.com"), path: "/away.php", params: []string{"to"}},
+	{name: "linkedin", host: hostIs("www.linkedin.com", "linkedin.com"), path: "/redir/redirect", params: []string{"url"}},
+	{name: "steam", host: hostIs("steamcommunity.com"), path: "/linkfilter", params: []string{"url", "u"}},
+	{name: "proofpoint", host: hostIs("urldefense.com"), path: "/v3/__", extract: proofpointV3},
+}
+
+// proofpointV3 decodes https://urldefense.com/v3/__<url>__;<checksum>
+func proofpointV3(u *url.URL) string {
+	raw := strings.TrimPrefix(u.EscapedPath(), "/v3/__")
+	if u.RawQuery != "" {
+		raw += "?" + u.RawQuery
+	}
+	if i := strings.Index(raw, "__;"); i >= 0 {
+		raw = raw[:i]
+	} else {
+		raw = strings.TrimSuffix(raw, "__")
+	}
+	return raw
+}
+
+// Unwrapper peels link-wrapper and safelink URLs back to their destination
+// without any network access, so the tracker never sees the request
+type Unwrapper struct {
+	rules []unwrapRule
+}
+
+// NewUnwrapper returns an Unwrapper with the built-in wrapper rules
+func NewUnwrapper() *Unwrapper {
+	return &Unwrapper{rules: defaultUnwrapRules}
+}
+
+// unwrapOnce returns the wrapped destination if u is a known wrapper
+func (w *Unwrapper) unwrapOnce(u *url.URL) (*url.URL, bool) {
+	host := strings.ToLower(u.Hostname())
+	for _, rule := range w.rules {
+		if !rule.host(host) || !strings.HasPrefix(u.Path, rule.path) {
+			continue
+		}
+
+		var target string
+		if rule.extract != nil {
+			target = rule.extract(u)
+		} else {
+			q := u.Query()
+			for _, p := range rule.params {
+				if target = q.Get(p); target != "" {
+					break
+				}
+			}
+		}
+
+		if inner, ok := parseDestination(target); ok {
+			return inner, true
+		}
+	}
+	return nil, false
+}
+
+// parseDestination accepts absolute http(s) URLs, decoding one extra level
+// of percent-encoding for wrappers that double-escape their payload
+func parseDestination(s string) (*url.URL, bool) {
+	s = strings.TrimSpace(s)
+	for i := 0; i < 2 && s != ""; i++ {
+		if u, err := url.Parse(s); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			return u, true
+		}
+		unescaped, err := url.QueryUnescape(s)
+		if err != nil || unescaped == s {
+			break
+		}
+		s = unescaped
+	}
+	return nil, false
+}
+
+// Unwrap removes nested wrappers and returns the innermost URL along with a
+// hop for each wrapper that was peeled off. These hops are marked Unwrapped
+// and carry no status since none of them were fetched.
+func (w *Unwrapper) Unwrap(u *url.URL) (*url.URL, []Hop) {
+	var hops []Hop
+	for i := 0; i < maxUnwrapDepth; i++ {
+		inner, ok := w.unwrapOnce(u)
+		if !ok {
+			break
+		}
+		var prev *Hop
+		if len(hops) > 0 {
+			prev = &hops[len(hops)-1]
+		}
+		hop := newHop(u, 0, prev)
+		hop.Unwrapped = true
+		hops = append(hops, hop)
+		u = inner
+	}
+	return u, hops
+}
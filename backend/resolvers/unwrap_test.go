@@ -0,0 +1,105 @@
+package resolvers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
+)
+
+func TestUnwrapper(t *testing.T) {
+	w := NewUnwrapper()
+	tests := []struct {
+		name string
+		in   string
+		want string
+		hops int
+	}{
+		{"Google", "https://www.google.com/url?sa=t&q=https%3A%2F%2Fexample.com%2Fa%3Fb%3D1&usg=x", "https://example.com/a?b=1", 1},
+		{"Google ccTLD", "https://www.google.co.uk/url?url=https://example.com/", "https://example.com/", 1},
+		{"Google Lookalike", "https://google.evil.com/url?q=https://example.com/", "https://google.evil.com/url?q=https://example.com/", 0},
+		{"Facebook", "https://l.facebook.com/l.php?u=https%3A%2F%2Fexample.org%2F&h=AT0", "https://example.org/", 1},
+		{"Safelinks", "https://nam12.safelinks.protection.outlook.com/?url=https%3A%2F%2Fexample.net%2Fdoc&data=05", "https://example.net/doc", 1},
+		{"Slack", "https://slack-redir.net/link?url=https%3A%2F%2Fexample.com", "https://example.com", 1},
+		{"Tumblr", "https://t.umblr.com/redirect?z=https%3A%2F%2Fexample.com%2Fpost&t=abc", "https://example.com/post", 1},
+		{"Double Encoded", "https://l.instagram.com/?u=https%253A%252F%252Fexample.com%252F", "https://example.com/", 1},
+		{"Proofpoint", "https://urldefense.com/v3/__https://example.com/path?x=1__;!!abc$", "https://example.com/path?x=1", 1},
+		{"Nested", "https://www.google.com/url?q=" + url.QueryEscape("https://l.facebook.com/l.php?u="+url.QueryEscape("https://example.com/deep")), "https://example.com/deep", 2},
+		{"Non-HTTP Payload", "https://www.google.com/url?q=javascript:alert(1)", "https://www.google.com/url?q=javascript:alert(1)", 0},
+		{"Plain URL", "https://example.com/url?q=https://other.com", "https://example.com/url?q=https://other.com", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.in)
+			got, hops := w.Unwrap(u)
+			if got.String() != tt.want {
+				t.Errorf("Unwrap(%s) = %s; want %s", tt.in, got, tt.want)
+			}
+			if len(hops) != tt.hops {
+				t.Errorf("Expected %d wrapper hops, got %d", tt.hops, len(hops))
+			}
+			for _, h := range hops {
+				if !h.Unwrapped || h.Status != 0 {
+					t.Errorf("Expected offline unwrapped hop, got %+v", h)
+				}
+			}
+		})
+	}
+}
+
+func TestResolverManager_UnwrapsBeforeFetching(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	manager := NewResolverManager(&MockCache{store: make(map[string]*Result)})
+	manager.SetEgressPolicy(transport.LocalPolicy())
+	yt, _ := NewYouTubeResolver("")
+	manager.Register(yt)
+	manager.Register(NewOpenGraphResolver())
+	manager.SetExpander(NewUnshortenerResolver(manager))
+
+	// The inner YouTube link resolves without any request, not even to the wrapper
+	raw := "https://www.google.com/url?q=" + url.QueryEscape("https://youtu.be/dQw4w9WgXcQ")
+	res := manager.ResolveMulti(context.Background(), []string{raw})[raw]
+	if res == nil || res.Title != "Mock Title for Video dQw4w9WgXcQ" {
+		t.Fatalf("Expected wrapped YouTube link to resolve, got %+v", res)
+	}
+	if res.FinalURL != "https://youtu.be/dQw4w9WgXcQ" {
+		t.Errorf("Expected final URL of the inner link, got %q", res.FinalURL)
+	}
+	if len(res.RedirectChain) != 2 || !res.RedirectChain[0].Unwrapped || !res.RedirectChain[1].CrossDomain {
+		t.Errorf("Unexpected chain %+v", res.RedirectChain)
+	}
+
+	// A shortener redirecting into a wrapper never hits the wrapper host
+	mux := http.NewServeMux()
+	mux.HandleFunc("/s", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://l.facebook.com/l.php?u="+url.QueryEscape(ts.URL+"/landing"), http.StatusFound)
+	})
+	short := httptest.NewServer(mux)
+	defer short.Close()
+
+	u, _ := url.Parse(short.URL + "/s")
+	final, chain, err := NewUnshortenerResolver(manager).Expand(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if final.String() != ts.URL+"/landing" {
+		t.Errorf("Expected landing page, got %s", final)
+	}
+	if len(chain) != 3 || !chain[1].Unwrapped {
+		t.Errorf("Expected shortener, wrapper and landing hops, got %+v", chain)
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("Expected only the landing page to be requested, got %d requests", requests)
+	}
+}
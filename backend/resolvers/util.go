@@ -1,25 +1,155 @@
 package resolvers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sph/youtube-url-replacer/backend/transport"
+	"golang.org/x/net/publicsuffix"
 )
 
-// SafeHttpClient returns an http.Client with SSRF protection
-func SafeHttpClient(timeout time.Duration) *http.Client {
+var (
+	metaTagRegex  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	linkTagRegex  = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	attrRegex     = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	titleRegex    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlLangRegex = regexp.MustCompile(`(?is)<html\s[^>]*\blang\s*=\s*["']([^"']+)["']`)
+	ampHTMLRegex  = regexp.MustCompile(`(?is)<html\b[^>]*\s(?:amp|⚡)(?:\s|=|>)`)
+
+	scriptLocationRegex = regexp.MustCompile(`(?:\blocation(?:\.href)?\s*=\s*["']([^"']+)["']|\blocation\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\))`)
+)
+
+// SafeHttpClient returns an http.Client that only reaches destinations the egress policy allows.
+// Retries sit outside the safe transport's politeness so every attempt waits its turn with the origin.
+func SafeHttpClient(timeout time.Duration, policy *transport.Policy) *http.Client {
 	return &http.Client{
-		Transport: transport.NewSafeTransport(),
+		Transport: &guardedTransport{base: policy.Retry.Wrap(transport.NewSafeTransport(policy)), audit: policy.Audit},
 		Timeout:   timeout,
 	}
 }
 
-// ExtractMetadata parses HTML and attempts to find a title
+// ErrBlockedHost is returned for requests to hosts the threat feeds mark malicious
+var ErrBlockedHost = errors.New("host is on a malicious-host blocklist")
+
+type fetchGuardKey struct{}
+
+// withFetchGuard attaches a check run before every outbound request made with ctx,
+// including redirects and the extra hops resolvers follow on their own
+func withFetchGuard(ctx context.Context, guard func(u *url.URL) error) context.Context {
+	return context.WithValue(ctx, fetchGuardKey{}, guard)
+}
+
+// guardedTransport refuses requests rejected by the context's fetch guard and
+// reports the refusals to the audit sink, since they never reach the audited transport
+type guardedTransport struct {
+	base  http.RoundTripper
+	audit transport.AuditSink
+}
+
+func (t *guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if guard, ok := req.Context().Value(fetchGuardKey{}).(func(*url.URL) error); ok {
+		if err := guard(req.URL); err != nil {
+			transport.RecordRefusal(t.audit, req, "malicious_host")
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// validatorsFrom records the ETag and Last-Modified of a response, or returns nil when it has neither
+func validatorsFrom(resolver, rawURL string, h http.Header) *Validators {
+	etag, lastModified := h.Get("ETag"), h.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return nil
+	}
+	return &Validators{Resolver: resolver, URL: rawURL, ETag: etag, LastModified: lastModified}
+}
+
+// conditionalGet sends req with the validators attached and reports whether
+// the origin answered 304 Not Modified; a 200 means the content changed
+func conditionalGet(client *http.Client, req *http.Request, v *Validators) (bool, error) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return true, nil
+	case http.StatusOK:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+}
+
+// parseAttrs returns the lower-cased attribute names and unescaped values of a single tag
+func parseAttrs(tag string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attrRegex.FindAllStringSubmatch(tag, -1) {
+		name := strings.ToLower(m[1])
+		if _, exists := attrs[name]; exists {
+			continue
+		}
+		val := m[2]
+		if val == "" {
+			val = m[3]
+		}
+		attrs[name] = html.UnescapeString(val)
+	}
+	return attrs
+}
+
+// parseTime accepts the timestamp formats commonly found in meta tags and APIs
+func parseTime(s string) *time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	layouts := []string{
+		time.RFC3339Nano,
+		time.RFC3339,
+		"2006-01-02T15:04:05Z0700",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// pageHints carries navigation signals found while parsing a page: where an
+// interstitial wants to send the browser and which alternate versions it declares
+type pageHints struct {
+	Refresh   string // content of <meta http-equiv="refresh">
+	Script    string // target of a JavaScript location assignment
+	Canonical string // <link rel="canonical">
+	AMPHTML   string // <link rel="amphtml">
+	OEmbed    string // <link rel="alternate" type="application/json+oembed">
+	IsAMP     bool   // <html amp> / <html ⚡>
+}
+
+// ExtractMetadata parses HTML and attempts to find a title along with
+// any OpenGraph, Twitter card and standard meta information
 func ExtractMetadata(r io.Reader) (*Result, error) {
 	// Read a limited amount of data to avoid memory issues (e.g., 512KB)
 	limitReader := io.LimitReader(r, 512*1024)
@@ -28,40 +158,176 @@ func ExtractMetadata(r io.Reader) (*Result, error) {
 		return nil, err
 	}
 
-	html := string(body)
+	res, _ := parsePage(string(body))
+	if res.Title == "" {
+		return nil, fmt.Errorf("no title found")
+	}
+
+	return res, nil
+}
+
+// parsePage extracts metadata and navigation hints from an HTML document.
+// Unlike ExtractMetadata it returns a result even when no title was found.
+func parsePage(doc string) (*Result, pageHints) {
 	res := &Result{}
+	var hints pageHints
 
-	// Try OpenGraph title first
-	ogTitleRegex := regexp.MustCompile(`(?i)<meta\s+property=["']og:title["']\s+content=["']([^"']+)["']`)
-	if matches := ogTitleRegex.FindStringSubmatch(html); len(matches) > 1 {
-		res.Title = matches[1]
+	// Collect meta tags keyed by property/name/itemprop; the first occurrence wins
+	meta := make(map[string]string)
+	for _, tag := range metaTagRegex.FindAllString(doc, -1) {
+		attrs := parseAttrs(tag)
+		content, ok := attrs["content"]
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(attrs["http-equiv"]), "refresh") && hints.Refresh == "" {
+			hints.Refresh = strings.TrimSpace(content)
+		}
+		for _, keyAttr := range []string{"property", "name", "itemprop"} {
+			if key := strings.ToLower(attrs[keyAttr]); key != "" {
+				if _, exists := meta[key]; !exists {
+					meta[key] = strings.TrimSpace(content)
+				}
+			}
+		}
 	}
 
-	// Fallback to <title> tag
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := meta[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	// Try OpenGraph title first, then fallback to <title> tag
+	res.Title = first("og:title", "twitter:title")
 	if res.Title == "" {
-		titleRegex := regexp.MustCompile(`(?i)<title>(.*?)</title>`)
-		if matches := titleRegex.FindStringSubmatch(html); len(matches) > 1 {
-			res.Title = matches[1]
+		if matches := titleRegex.FindStringSubmatch(doc); len(matches) > 1 {
+			res.Title = html.UnescapeString(matches[1])
 		}
 	}
+	res.Title = strings.Join(strings.Fields(res.Title), " ")
+
+	res.Description = first("og:description", "twitter:description", "description")
+	res.SiteName = first("og:site_name", "application-name")
+	res.Author = first("author", "article:author", "twitter:creator")
+	res.PublishedAt = parseTime(first("article:published_time", "datepublished", "date"))
+	res.UpdatedAt = parseTime(first("article:modified_time", "og:updated_time", "datemodified"))
+	res.CanonicalURL = first("og:url")
 
-	// Try OpenGraph description
-	ogDescRegex := regexp.MustCompile(`(?i)<meta\s+property=["']og:description["']\s+content=["']([^"']+)["']`)
-	if matches := ogDescRegex.FindStringSubmatch(html); len(matches) > 1 {
-		res.Description = matches[1]
+	if img := first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src"); img != "" {
+		res.Image = &Image{URL: img}
+		res.Image.Width, _ = strconv.Atoi(meta["og:image:width"])
+		res.Image.Height, _ = strconv.Atoi(meta["og:image:height"])
 	}
 
-	// Clean up title (unescape common entities, remove extra whitespace)
-	res.Title = strings.TrimSpace(res.Title)
-	res.Title = strings.ReplaceAll(res.Title, "&amp;", "&")
-	res.Title = strings.ReplaceAll(res.Title, "&quot;", "\"")
-	res.Title = strings.ReplaceAll(res.Title, "&#39;", "'")
-	res.Title = strings.ReplaceAll(res.Title, "&lt;", "<")
-	res.Title = strings.ReplaceAll(res.Title, "&gt;", ">")
+	// <link rel="canonical"> is more authoritative than og:url
+	for _, tag := range linkTagRegex.FindAllString(doc, -1) {
+		attrs := parseAttrs(tag)
+		href := strings.TrimSpace(attrs["href"])
+		if href == "" {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(attrs["rel"])) {
+		case "canonical":
+			if hints.Canonical == "" {
+				hints.Canonical = href
+				res.CanonicalURL = href
+			}
+		case "amphtml":
+			if hints.AMPHTML == "" {
+				hints.AMPHTML = href
+			}
+		}
+		if rels := strings.Fields(strings.ToLower(attrs["rel"])); len(rels) > 0 && rels[0] == "alternate" &&
+			strings.EqualFold(attrs["type"], "application/json+oembed") && hints.OEmbed == "" {
+			hints.OEmbed = href
+		}
+	}
 
-	if res.Title == "" {
-		return nil, fmt.Errorf("no title found")
+	hints.IsAMP = ampHTMLRegex.MatchString(doc)
+	if m := scriptLocationRegex.FindStringSubmatch(doc); m != nil {
+		hints.Script = strings.ReplaceAll(m[1]+m[2], `\/`, "/")
 	}
 
-	return res, nil
+	if matches := htmlLangRegex.FindStringSubmatch(doc); len(matches) > 1 {
+		res.Language = strings.TrimSpace(matches[1])
+	} else if locale := first("og:locale"); locale != "" {
+		res.Language = strings.ReplaceAll(locale, "_", "-")
+	}
+
+	return res, hints
+}
+
+// absolutizeURLs resolves relative image and canonical URLs against the page URL
+func absolutizeURLs(res *Result, base *url.URL) {
+	resolve := func(ref string) string {
+		u, err := base.Parse(ref)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return ""
+		}
+		return u.String()
+	}
+	if res.Image != nil {
+		if res.Image.URL = resolve(res.Image.URL); res.Image.URL == "" {
+			res.Image = nil
+		}
+	}
+	if res.CanonicalURL != "" {
+		res.CanonicalURL = resolve(res.CanonicalURL)
+	}
+}
+
+// redirectTracker enforces a hop budget and detects loops while a resolver
+// walks a chain of redirects (HTTP, meta-refresh or canonical links)
+type redirectTracker struct {
+	seen    map[string]bool
+	hops    int
+	maxHops int
+}
+
+func newRedirectTracker(maxHops int) *redirectTracker {
+	return &redirectTracker{seen: make(map[string]bool), maxHops: maxHops}
+}
+
+// visit records a URL, failing if it has been seen before in this chain
+func (t *redirectTracker) visit(u string) error {
+	if t.seen[u] {
+		return fmt.Errorf("redirect loop detected at %s", u)
+	}
+	t.seen[u] = true
+	return nil
+}
+
+// next consumes one hop and reports whether the budget allowed it
+func (t *redirectTracker) next() bool {
+	if t.hops >= t.maxHops {
+		return false
+	}
+	t.hops++
+	return true
+}
+
+// registrableDomain returns the eTLD+1 of a host ("news.bbc.co.uk" -> "bbc.co.uk"),
+// falling back to the host itself for IPs and unknown suffixes
+func registrableDomain(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return d
+	}
+	return host
+}
+
+// newHop describes a visit to u, comparing it with the previous hop in the chain
+func newHop(u *url.URL, status int, prev *Hop) Hop {
+	hop := Hop{URL: u.String(), Status: status}
+	if prev != nil {
+		if p, err := url.Parse(prev.URL); err == nil {
+			hop.CrossDomain = registrableDomain(p.Hostname()) != registrableDomain(u.Hostname())
+			hop.Downgrade = p.Scheme == "https" && u.Scheme == "http"
+		}
+	}
+	return hop
 }
@@ -1,13 +1,19 @@
 package resolvers
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/sph/youtube-url-replacer/backend/transport"
 )
 
 func TestSafeHttpClient_BlocksPrivate(t *testing.T) {
-	client := SafeHttpClient(1 * time.Second)
+	client := SafeHttpClient(1*time.Second, transport.DefaultPolicy())
 	_, err := client.Get("http://127.0.0.1:12345")
 	if err == nil {
 		t.Error("Expected error for 127.0.0.1, got nil")
@@ -16,6 +22,62 @@ func TestSafeHttpClient_BlocksPrivate(t *testing.T) {
 	}
 }
 
+func TestSafeHttpClient_AppliesPoliteness(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	policy := transport.LocalPolicy()
+	policy.Politeness = transport.NewPoliteness(1, 0, 0)
+	client := SafeHttpClient(1*time.Second, policy)
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Expected a response, got %v", err)
+	}
+	resp.Body.Close()
+
+	// The 429 holds the origin back longer than the client timeout
+	if _, err := client.Get(ts.URL); !errors.Is(err, transport.ErrOriginBackoff) {
+		t.Errorf("Expected ErrOriginBackoff, got %v", err)
+	}
+	// The first request's retries each saw the 429
+	if stats := policy.Politeness.Stats(); stats.Throttled != 3 {
+		t.Errorf("Expected 3 throttled responses, got %+v", stats)
+	}
+}
+
+func TestSafeHttpClient_RetriesWithDefaults(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+
+	// The production setup: default policy and retries, main's politeness limits, a 2s client
+	policy := transport.DefaultPolicy()
+	policy.AllowPrivate = true
+	policy.Politeness = transport.NewPoliteness(4, 120, 10)
+	client := SafeHttpClient(2*time.Second, policy)
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Expected the retries to succeed, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("Expected 200 after 3 calls, got %d after %d", resp.StatusCode, calls.Load())
+	}
+}
+
 func TestExtractMetadata(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -61,3 +123,49 @@ func TestExtractMetadata(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractMetadata_RichFields(t *testing.T) {
+	page := `<html lang="en-GB"><head>
+		<title>Fallback</title>
+		<meta property="og:title" content="Rich &amp; Rare">
+		<meta content="Example Site" property="og:site_name">
+		<meta name="author" content='Jane "JD" Doe'>
+		<meta property="og:image" content="/img/cover.png">
+		<meta property="og:image:width" content="1200">
+		<meta property="og:image:height" content="630">
+		<meta property="article:published_time" content="2024-03-01T10:00:00Z">
+		<meta property="article:modified_time" content="2024-03-02">
+		<meta property="og:url" content="https://example.com/og">
+		<link rel="canonical" href="https://example.com/canonical">
+	</head></html>`
+
+	res, err := ExtractMetadata(strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("ExtractMetadata failed: %v", err)
+	}
+
+	if res.Title != "Rich & Rare" {
+		t.Errorf("Got title %q", res.Title)
+	}
+	if res.SiteName != "Example Site" {
+		t.Errorf("Got site name %q", res.SiteName)
+	}
+	if res.Author != `Jane "JD" Doe` {
+		t.Errorf("Got author %q", res.Author)
+	}
+	if res.Image == nil || res.Image.URL != "/img/cover.png" || res.Image.Width != 1200 || res.Image.Height != 630 {
+		t.Errorf("Got image %+v", res.Image)
+	}
+	if res.PublishedAt == nil || !res.PublishedAt.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Got published %v", res.PublishedAt)
+	}
+	if res.UpdatedAt == nil || res.UpdatedAt.Format("2006-01-02") != "2024-03-02" {
+		t.Errorf("Got updated %v", res.UpdatedAt)
+	}
+	if res.CanonicalURL != "https://example.com/canonical" {
+		t.Errorf("Got canonical %q", res.CanonicalURL)
+	}
+	if res.Language != "en-GB" {
+		t.Errorf("Got language %q", res.Language)
+	}
+}
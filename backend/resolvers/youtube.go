@@ -3,14 +3,19 @@ package resolvers
 import (
 	"context"
 	"fmt"
+	"log"
+	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/sph/youtube-url-replacer/backend/transport"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
 type YouTubeResolver struct {
+	apiKey  string
 	service *youtube.Service
 }
 
@@ -19,12 +24,49 @@ func NewYouTubeResolver(apiKey string) (*YouTubeResolver, error) {
 		return &YouTubeResolver{service: nil}, nil // Mock mode
 	}
 
-	ctx := context.Background()
-	service, err := youtube.NewService(ctx, option.WithAPIKey(apiKey))
+	r := &YouTubeResolver{apiKey: apiKey}
+	if err := r.newService(transport.DefaultPolicy()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetEgressPolicy sends API calls through a client with the given policy
+func (r *YouTubeResolver) SetEgressPolicy(p *transport.Policy) {
+	if r.apiKey == "" {
+		return
+	}
+	if err := r.newService(p); err != nil {
+		log.Printf("Failed to apply egress policy to YouTube resolver: %v", err)
+	}
+}
+
+// newService builds the API client on our safe transport, so API calls are
+// subject to the egress policy and audit log like every other fetch. The
+// per-origin politeness limits are left out: a batch of videos would queue
+// behind them, and the API quota is ours, not a scraped site's.
+func (r *YouTubeResolver) newService(p *transport.Policy) error {
+	client := SafeHttpClient(2*time.Second, p.WithoutPoliteness())
+	client.Transport = &apiKeyTransport{key: r.apiKey, base: client.Transport}
+	service, err := youtube.NewService(context.Background(), option.WithHTTPClient(client))
 	if err != nil {
-		return nil, fmt.Errorf("error creating youtube service: %v", err)
+		return fmt.Errorf("error creating youtube service: %v", err)
 	}
-	return &YouTubeResolver{service: service}, nil
+	r.service = service
+	return nil
+}
+
+// apiKeyTransport authenticates API calls. option.WithAPIKey is ignored with a
+// custom client, and a header keeps the key out of URLs.
+type apiKeyTransport struct {
+	key  string
+	base http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Goog-Api-Key", t.key)
+	return t.base.RoundTrip(req)
 }
 
 func (r *YouTubeResolver) Name() string {
@@ -33,10 +75,11 @@ func (r *YouTubeResolver) Name() string {
 
 func (r *YouTubeResolver) CanHandle(u *url.URL) bool {
 	host := strings.ToLower(u.Host)
-	return host == "youtube.com" || host == "www.youtube.com" || host == "youtu.be"
+	return host == "youtube.com" || host == "www.youtube.com" || host == "m.youtube.com" || host == "youtu.be"
 }
 
-func (r *YouTubeResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
+// extractVideoID handles watch, youtu.be, shorts and live URLs
+func extractVideoID(u *url.URL) string {
 	videoID := ""
 
 	if strings.ToLower(u.Host) == "youtu.be" {
@@ -50,16 +93,40 @@ func (r *YouTubeResolver) Resolve(ctx context.Context, u *url.URL) (*Result, err
 			videoID = strings.TrimPrefix(u.Path, "/live/")
 		}
 	}
+	return videoID
+}
+
+// Canonicalize maps every form of a video link to https://www.youtube.com/watch?v=ID,
+// dropping timestamps and playlist context
+func (r *YouTubeResolver) Canonicalize(u *url.URL) (*url.URL, bool) {
+	videoID := extractVideoID(u)
+	if videoID == "" {
+		return nil, false
+	}
+	return &url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/watch", RawQuery: "v=" + url.QueryEscape(videoID)}, true
+}
+
+func (r *YouTubeResolver) Resolve(ctx context.Context, u *url.URL) (*Result, error) {
+	videoID := extractVideoID(u)
 
 	if videoID == "" {
 		return nil, fmt.Errorf("could not extract video ID from YouTube URL: %s", u.String())
 	}
 
+	canonical := "https://www.youtube.com/watch?v=" + url.QueryEscape(videoID)
+
 	// Handle mock mode
 	if r.service == nil {
 		return &Result{
-			Title:    fmt.Sprintf("Mock Title for Video %s", videoID),
-			Platform: "YouTube",
+			Title:        fmt.Sprintf("Mock Title for Video %s", videoID),
+			Platform:     "YouTube",
+			SiteName:     "YouTube",
+			CanonicalURL: canonical,
+			Image: &Image{
+				URL:    fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", url.PathEscape(videoID)),
+				Width:  480,
+				Height: 360,
+			},
 		}, nil
 	}
 
@@ -74,8 +141,31 @@ func (r *YouTubeResolver) Resolve(ctx context.Context, u *url.URL) (*Result, err
 	}
 
 	item := response.Items[0]
-	return &Result{
-		Title:    item.Snippet.Title,
-		Platform: "YouTube",
-	}, nil
+	res := &Result{
+		Title:        item.Snippet.Title,
+		Platform:     "YouTube",
+		SiteName:     "YouTube",
+		Author:       item.Snippet.ChannelTitle,
+		PublishedAt:  parseTime(item.Snippet.PublishedAt),
+		CanonicalURL: canonical,
+		Image:        bestThumbnail(item.Snippet.Thumbnails),
+		Language:     item.Snippet.DefaultLanguage,
+	}
+	if res.Language == "" {
+		res.Language = item.Snippet.DefaultAudioLanguage
+	}
+	return res, nil
+}
+
+// bestThumbnail picks the largest available thumbnail
+func bestThumbnail(t *youtube.ThumbnailDetails) *Image {
+	if t == nil {
+		return nil
+	}
+	for _, th := range []*youtube.Thumbnail{t.Maxres, t.Standard, t.High, t.Medium, t.Default} {
+		if th != nil && th.Url != "" {
+			return &Image{URL: th.Url, Width: int(th.Width), Height: int(th.Height)}
+		}
+	}
+	return nil
 }
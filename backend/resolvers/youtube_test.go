@@ -40,6 +40,12 @@ func TestYouTubeResolver_Resolve(t *testing.T) {
 	if res.Title != "Mock Title for Video dQw4w9WgXcQ" {
 		t.Errorf("Unexpected title: %s", res.Title)
 	}
+	if res.CanonicalURL != "https://www.youtube.com/watch?v=dQw4w9WgXcQ" {
+		t.Errorf("Unexpected canonical URL: %s", res.CanonicalURL)
+	}
+	if res.Image == nil || res.Image.URL != "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg" {
+		t.Errorf("Unexpected thumbnail: %+v", res.Image)
+	}
 
 	u2, _ := url.Parse("https://youtu.be/dQw4w9WgXcQ")
 	res2, err := r.Resolve(ctx, u2)
@@ -0,0 +1,267 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditRecord describes one outbound request. The URL is kept only as its
+// SHA-256, so the log shows where we went without recording what users read.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Resolver string    `json:"resolver,omitempty"`
+	Method   string    `json:"method"`
+	Host     string    `json:"host"`
+	URLHash  string    `json:"urlHash"`
+	IP       string    `json:"ip,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Bytes    int64     `json:"bytes"`
+	// DurationMs runs from sending the request, including any politeness
+	// wait, to closing the body
+	DurationMs int64 `json:"durationMs"`
+	// Decision is "allowed", "blocked" when the egress policy, a fetch guard
+	// or an origin's backoff refused the request, or "failed" when the request
+	// or its body broke off
+	Decision string `json:"decision"`
+	// Reason is the block or failure reason: a policy reason such as
+	// "blocked_ip", "origin_backoff", a limit code such as "transfer_too_slow",
+	// or "error"
+	Reason string `json:"reason,omitempty"`
+}
+
+// AuditSink receives a record for every outbound request. Record is called
+// from request goroutines and must be safe for concurrent use.
+type AuditSink interface {
+	Record(rec AuditRecord)
+}
+
+// JSONLinesSink writes each record as one line of JSON. Records that can't be
+// written are logged and counted, so a full disk doesn't lose them silently.
+type JSONLinesSink struct {
+	mu       sync.Mutex
+	w        io.Writer
+	failures atomic.Int64
+}
+
+// NewJSONLinesSink writes records to w; use os.Stdout for the stdout sink
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+// OpenAuditFile appends records to the JSON lines file at path, creating it if needed
+func OpenAuditFile(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONLinesSink(f), nil
+}
+
+func (s *JSONLinesSink) Record(rec AuditRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		s.fail(rec, err)
+		return
+	}
+	s.mu.Lock()
+	_, err = s.w.Write(append(line, '\n'))
+	s.mu.Unlock()
+	if err != nil {
+		s.fail(rec, err)
+	}
+}
+
+// Failures is the number of records that couldn't be written
+func (s *JSONLinesSink) Failures() int64 {
+	return s.failures.Load()
+}
+
+func (s *JSONLinesSink) fail(rec AuditRecord, err error) {
+	s.failures.Add(1)
+	slog.Error("Failed to write audit record", "error", err, "host", rec.Host, "url_hash", rec.URLHash, "decision", rec.Decision)
+}
+
+// SlogSink logs each record at Info level
+type SlogSink struct {
+	Logger *slog.Logger
+}
+
+func (s *SlogSink) Record(rec AuditRecord) {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Info("Outbound fetch",
+		"resolver", rec.Resolver,
+		"method", rec.Method,
+		"host", rec.Host,
+		"url_hash", rec.URLHash,
+		"ip", rec.IP,
+		"status", rec.Status,
+		"bytes", rec.Bytes,
+		"duration_ms", rec.DurationMs,
+		"decision", rec.Decision,
+		"reason", rec.Reason,
+	)
+}
+
+type auditResolverKey struct{}
+
+// WithAuditResolver names the resolver on whose behalf requests made with ctx are sent
+func WithAuditResolver(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, auditResolverKey{}, name)
+}
+
+// HashURL is the lower-case hex SHA-256 of a URL, as recorded in the audit log
+func HashURL(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordRefusal reports a request refused before it reached the transport,
+// such as by a fetch guard, as blocked for reason. A nil sink records nothing.
+func RecordRefusal(sink AuditSink, req *http.Request, reason string) {
+	if sink == nil {
+		return
+	}
+	rec := newAuditRecord(req)
+	rec.Decision, rec.Reason = "blocked", reason
+	sink.Record(rec)
+}
+
+func newAuditRecord(req *http.Request) AuditRecord {
+	rec := AuditRecord{
+		Time:    time.Now().UTC(),
+		Method:  req.Method,
+		Host:    strings.ToLower(req.URL.Hostname()),
+		URLHash: HashURL(req.URL.String()),
+	}
+	rec.Resolver, _ = req.Context().Value(auditResolverKey{}).(string)
+	return rec
+}
+
+// auditWrap returns a RoundTripper that reports every request through base to the sink
+func auditWrap(sink AuditSink, base http.RoundTripper) http.RoundTripper {
+	if sink == nil {
+		return base
+	}
+	return &auditTransport{sink: sink, base: base}
+}
+
+type auditTransport struct {
+	sink AuditSink
+	base http.RoundTripper
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := newAuditRecord(req)
+
+	var mu sync.Mutex
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			rec.IP = connIP(info.Conn)
+			mu.Unlock()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := t.base.RoundTrip(req)
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		rec.Decision, rec.Reason = auditDecision(err)
+		rec.DurationMs = time.Since(rec.Time).Milliseconds()
+		t.sink.Record(rec)
+		return nil, err
+	}
+	rec.Status = resp.StatusCode
+	resp.Body = &auditBody{ReadCloser: resp.Body, sink: t.sink, rec: rec}
+	return resp, nil
+}
+
+// auditDecision classifies a failed request
+func auditDecision(err error) (string, string) {
+	switch {
+	case errors.Is(err, ErrBlockedIP):
+		return "blocked", "blocked_ip"
+	case errors.Is(err, ErrBlockedHost):
+		return "blocked", "blocked_host"
+	case errors.Is(err, ErrBlockedPort):
+		return "blocked", "blocked_port"
+	case errors.Is(err, ErrOriginBackoff):
+		return "blocked", "origin_backoff"
+	}
+	if code := LimitCode(err); code != "" {
+		return "failed", code
+	}
+	return "failed", "error"
+}
+
+// connIP is the address we connected to; through a proxy, the validated
+// address the proxy was asked to reach
+func connIP(conn net.Conn) string {
+	if tc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tc.NetConn()
+	}
+	if dc, ok := conn.(*dialedConn); ok {
+		return dc.ip
+	}
+	if conn == nil || conn.RemoteAddr() == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// dialedConn remembers the destination of a proxied connection, whose remote address is the proxy's
+type dialedConn struct {
+	net.Conn
+	ip string
+}
+
+// auditBody counts the bytes read and records the request when the body is
+// closed, so limit errors on the body and the full size make it into the record
+type auditBody struct {
+	io.ReadCloser
+	sink AuditSink
+	rec  AuditRecord
+	once sync.Once
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.rec.Bytes += int64(n)
+	if err != nil && err != io.EOF && b.rec.Decision == "" {
+		b.rec.Decision, b.rec.Reason = auditDecision(err)
+	}
+	return n, err
+}
+
+func (b *auditBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		if b.rec.Decision == "" {
+			b.rec.Decision = "allowed"
+		}
+		b.rec.DurationMs = time.Since(b.rec.Time).Milliseconds()
+		b.sink.Record(b.rec)
+	})
+	return err
+}
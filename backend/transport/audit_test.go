@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memorySink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (s *memorySink) Record(rec AuditRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+}
+
+func (s *memorySink) last(t *testing.T) AuditRecord {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.records) == 0 {
+		t.Fatal("Expected an audit record")
+	}
+	return s.records[len(s.records)-1]
+}
+
+func TestAudit_RecordsRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+
+	sink := &memorySink{}
+	policy := LocalPolicy()
+	policy.Audit = sink
+	client := &http.Client{Transport: NewSafeTransport(policy)}
+
+	target := ts.URL + "/page?user=secret"
+	req, _ := http.NewRequestWithContext(WithAuditResolver(context.Background(), "opengraph"), http.MethodGet, target, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	if len(sink.records) != 0 {
+		t.Error("Expected the record to wait for the body to be closed")
+	}
+	resp.Body.Close()
+
+	rec := sink.last(t)
+	if rec.Resolver != "opengraph" || rec.Method != "GET" || rec.Host != "127.0.0.1" || rec.IP != "127.0.0.1" ||
+		rec.Status != 200 || rec.Bytes != 5 || rec.Decision != "allowed" || rec.Reason != "" || rec.Time.IsZero() {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+	if rec.URLHash != HashURL(target) || len(rec.URLHash) != 64 {
+		t.Errorf("Expected the URL hash, got %q", rec.URLHash)
+	}
+
+	// Refused destinations are recorded with the reason
+	policy = DefaultPolicy()
+	policy.Audit = sink
+	if _, err := (&http.Client{Transport: NewSafeTransport(policy)}).Get(target); !errors.Is(err, ErrBlockedIP) {
+		t.Fatalf("Expected ErrBlockedIP, got %v", err)
+	}
+	if rec := sink.last(t); rec.Decision != "blocked" || rec.Reason != "blocked_ip" || rec.Status != 0 {
+		t.Errorf("Unexpected record for a blocked request %+v", rec)
+	}
+
+	// And requests politeness holds back while an origin's Retry-After runs
+	policy = LocalPolicy()
+	policy.Audit = sink
+	policy.Politeness = NewPoliteness(1, 0, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	policy.Politeness.backOff(policy.Politeness.origin(req.URL.Host), time.Now().Add(time.Minute))
+	if _, err := (&http.Client{Transport: NewSafeTransport(policy)}).Do(req); !errors.Is(err, ErrOriginBackoff) {
+		t.Fatalf("Expected ErrOriginBackoff, got %v", err)
+	}
+	if rec := sink.last(t); rec.Decision != "blocked" || rec.Reason != "origin_backoff" || rec.IP != "" {
+		t.Errorf("Unexpected record for a held-back request %+v", rec)
+	}
+
+	// So are broken limits
+	policy = LocalPolicy()
+	policy.Audit = sink
+	policy.Limits.MaxBodyBytes = 2
+	resp, err = (&http.Client{Transport: NewSafeTransport(policy)}).Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if rec := sink.last(t); rec.Decision != "failed" || rec.Reason != "response_body_too_large" || rec.Status != 200 {
+		t.Errorf("Unexpected record for a broken limit %+v", rec)
+	}
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONLinesSink(&buf)
+	sink.Record(AuditRecord{Host: "example.com", URLHash: HashURL("https://example.com/private"), Status: 200, Decision: "allowed"})
+	sink.Record(AuditRecord{Host: "internal", Decision: "blocked", Reason: "blocked_host"})
+
+	if strings.Contains(buf.String(), "/private") {
+		t.Error("The full URL was written to the audit log")
+	}
+	scanner := bufio.NewScanner(&buf)
+	var lines []map[string]any
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[0]["host"] != "example.com" || lines[1]["reason"] != "blocked_host" {
+		t.Errorf("Unexpected lines %v", lines)
+	}
+}
+
+type brokenWriter struct{}
+
+func (brokenWriter) Write(p []byte) (int, error) { return 0, errors.New("no space left on device") }
+
+func TestJSONLinesSink_CountsFailures(t *testing.T) {
+	sink := NewJSONLinesSink(brokenWriter{})
+	sink.Record(AuditRecord{Host: "example.com", Decision: "allowed"})
+	sink.Record(AuditRecord{Host: "example.com", Decision: "allowed"})
+	if sink.Failures() != 2 {
+		t.Errorf("Expected 2 failed writes, got %d", sink.Failures())
+	}
+}
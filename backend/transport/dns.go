@@ -0,0 +1,291 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/singleflight"
+)
+
+// Resolver turns a hostname into addresses for SafeDialer
+type Resolver interface {
+	LookupNetIP(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// Upstream answers the lookups DNSCache doesn't have cached, along with how
+// long the answer may be kept
+type Upstream interface {
+	Lookup(ctx context.Context, host string) (ips []netip.Addr, ttl time.Duration, err error)
+}
+
+// SharedDNSCache is used by every SafeDialer whose policy doesn't name a Resolver,
+// so all clients in the process share one cache
+var SharedDNSCache = NewDNSCache(&SystemUpstream{})
+
+// DNSCache is an in-process cache in front of an Upstream. Answers are kept
+// for their TTL, clamped to [MinTTL, MaxTTL]; "no such host" answers for
+// NegativeTTL; temporary failures are never cached. Concurrent lookups for
+// the same host share one upstream query.
+//
+// The cache holds raw answers. SafeDialer checks every address against its
+// policy on each dial and connects to the validated IP, so a cached answer
+// can't widen what a client may reach, and a rebinding answer arriving
+// later can't change the address of a connection already checked.
+type DNSCache struct {
+	upstream Upstream
+
+	MinTTL      time.Duration
+	MaxTTL      time.Duration
+	NegativeTTL time.Duration
+	// LookupTimeout bounds a shared upstream query, which outlives any single caller's context
+	LookupTimeout time.Duration
+	// MaxEntries bounds memory; expired entries are dropped first, then the ones expiring soonest
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+	group   singleflight.Group
+	now     func() time.Time
+}
+
+type dnsEntry struct {
+	ips     []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// NewDNSCache returns a cache with conservative defaults
+func NewDNSCache(upstream Upstream) *DNSCache {
+	return &DNSCache{
+		upstream:      upstream,
+		MinTTL:        5 * time.Second,
+		MaxTTL:        5 * time.Minute,
+		NegativeTTL:   10 * time.Second,
+		LookupTimeout: 5 * time.Second,
+		MaxEntries:    10000,
+		entries:       make(map[string]dnsEntry),
+		now:           time.Now,
+	}
+}
+
+// LookupNetIP returns the cached answer for host or asks the upstream
+func (c *DNSCache) LookupNetIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.answer()
+	}
+
+	ch := c.group.DoChan(host, func() (any, error) {
+		// One caller giving up must not fail the others waiting on this query
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.LookupTimeout)
+		defer cancel()
+		ips, ttl, err := c.upstream.Lookup(lookupCtx, host)
+		entry := dnsEntry{ips: ips, err: err}
+		switch {
+		case err == nil && len(ips) > 0:
+			entry.expires = c.now().Add(min(max(ttl, c.MinTTL), c.MaxTTL))
+		case isNotFound(err) || (err == nil && len(ips) == 0):
+			entry.expires = c.now().Add(c.NegativeTTL)
+		default:
+			return entry, nil // Timeouts and server failures are retried on the next dial
+		}
+		c.store(host, entry)
+		return entry, nil
+	})
+
+	select {
+	case res := <-ch:
+		return res.Val.(dnsEntry).answer()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// answer returns a copy so callers can't modify the cached slice
+func (e dnsEntry) answer() ([]netip.Addr, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if len(e.ips) == 0 {
+		return nil, errors.New("no IP addresses found")
+	}
+	return append([]netip.Addr(nil), e.ips...), nil
+}
+
+func (c *DNSCache) store(host string, entry dnsEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[host]; !exists && len(c.entries) >= c.MaxEntries {
+		c.evict()
+	}
+	c.entries[host] = entry
+}
+
+// evict drops expired entries, or the one expiring soonest if none have expired
+func (c *DNSCache) evict() {
+	now := c.now()
+	var soonest string
+	for host, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, host)
+			continue
+		}
+		if soonest == "" || e.expires.Before(c.entries[soonest].expires) {
+			soonest = host
+		}
+	}
+	if len(c.entries) >= c.MaxEntries && soonest != "" {
+		delete(c.entries, soonest)
+	}
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// SystemUpstream resolves with Go's resolver, which honours /etc/hosts and
+// resolv.conf. The standard library doesn't report TTLs, so the DNS responses
+// are read as they arrive to find them; answers from /etc/hosts get DefaultTTL.
+type SystemUpstream struct {
+	// Server replaces the system nameservers, as host:port
+	Server string
+	// DefaultTTL is used when no TTL was seen; zero means one minute
+	DefaultTTL time.Duration
+
+	once     sync.Once
+	resolver *net.Resolver
+}
+
+type ttlRecorderKey struct{}
+
+func (u *SystemUpstream) Lookup(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	u.once.Do(func() {
+		dialer := &net.Dialer{Timeout: 2 * time.Second}
+		u.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				if u.Server != "" {
+					address = u.Server
+				}
+				conn, err := dialer.DialContext(ctx, network, address)
+				if err != nil {
+					return nil, err
+				}
+				if rec, ok := ctx.Value(ttlRecorderKey{}).(*ttlRecorder); ok {
+					// Go's resolver picks UDP or TCP framing by whether the conn is a PacketConn
+					if udp, ok := conn.(*net.UDPConn); ok {
+						return &ttlPacketConn{UDPConn: udp, rec: rec}, nil
+					}
+					return &ttlConn{Conn: conn, rec: rec}, nil
+				}
+				return conn, nil
+			},
+		}
+	})
+
+	rec := &ttlRecorder{}
+	ips, err := u.resolver.LookupNetIP(context.WithValue(ctx, ttlRecorderKey{}, rec), "ip", host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl, ok := rec.ttl()
+	if !ok {
+		ttl = u.DefaultTTL
+		if ttl == 0 {
+			ttl = time.Minute
+		}
+	}
+	return ips, ttl, nil
+}
+
+// ttlRecorder keeps the lowest TTL among the address records of a lookup,
+// which may span an A and an AAAA query
+type ttlRecorder struct {
+	mu   sync.Mutex
+	min  uint32
+	seen bool
+}
+
+func (r *ttlRecorder) ttl() (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.min) * time.Second, r.seen
+}
+
+// observe reads the TTLs out of one DNS response
+func (r *ttlRecorder) observe(msg []byte) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			return
+		}
+		switch h.Type {
+		case dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeCNAME:
+			if !r.seen || h.TTL < r.min {
+				r.min, r.seen = h.TTL, true
+			}
+		}
+		if err := p.SkipAnswer(); err != nil {
+			return
+		}
+	}
+}
+
+// ttlPacketConn hands every UDP DNS response read through it to a ttlRecorder
+type ttlPacketConn struct {
+	*net.UDPConn
+	rec *ttlRecorder
+}
+
+func (c *ttlPacketConn) Read(b []byte) (int, error) {
+	n, err := c.UDPConn.Read(b)
+	if n > 0 {
+		c.rec.observe(b[:n])
+	}
+	return n, err
+}
+
+// ttlConn does the same for TCP, where each message carries a two-byte length prefix
+type ttlConn struct {
+	net.Conn
+	rec    *ttlRecorder
+	stream []byte
+}
+
+func (c *ttlConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n == 0 {
+		return n, err
+	}
+	c.stream = append(c.stream, b[:n]...)
+	for len(c.stream) >= 2 {
+		size := int(binary.BigEndian.Uint16(c.stream))
+		if len(c.stream) < 2+size {
+			break
+		}
+		c.rec.observe(c.stream[2 : 2+size])
+		c.stream = c.stream[2+size:]
+	}
+	return n, err
+}
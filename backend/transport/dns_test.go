@@ -0,0 +1,356 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeUpstream answers from a table and counts queries
+type fakeUpstream struct {
+	mu      sync.Mutex
+	answers map[string][]netip.Addr
+	ttl     time.Duration
+	err     error
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (f *fakeUpstream) Lookup(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	f.calls.Add(1)
+	if f.release != nil {
+		<-f.release
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	ips, ok := f.answers[host]
+	if !ok {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, f.ttl, nil
+}
+
+// testClock is a controllable clock for cache expiry
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestCache(up Upstream) (*DNSCache, *testClock) {
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	cache := NewDNSCache(up)
+	cache.now = clock.now
+	return cache, clock
+}
+
+func TestDNSCache_RespectsTTL(t *testing.T) {
+	up := &fakeUpstream{answers: map[string][]netip.Addr{"example.com": addrs("93.184.216.34")}, ttl: 30 * time.Second}
+	cache, clock := newTestCache(up)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		ips, err := cache.LookupNetIP(ctx, "Example.COM.")
+		if err != nil || len(ips) != 1 {
+			t.Fatalf("Unexpected answer %v (%v)", ips, err)
+		}
+	}
+	if up.calls.Load() != 1 {
+		t.Errorf("Expected 1 upstream query, got %d", up.calls.Load())
+	}
+
+	clock.advance(29 * time.Second)
+	cache.LookupNetIP(ctx, "example.com")
+	if up.calls.Load() != 1 {
+		t.Errorf("Entry expired before its TTL")
+	}
+
+	clock.advance(2 * time.Second)
+	cache.LookupNetIP(ctx, "example.com")
+	if up.calls.Load() != 2 {
+		t.Errorf("Entry outlived its TTL")
+	}
+}
+
+func TestDNSCache_ClampsTTL(t *testing.T) {
+	up := &fakeUpstream{answers: map[string][]netip.Addr{"example.com": addrs("93.184.216.34")}}
+	cache, clock := newTestCache(up)
+	ctx := context.Background()
+
+	// TTL 0 is raised to MinTTL
+	cache.LookupNetIP(ctx, "example.com")
+	clock.advance(cache.MinTTL - time.Second)
+	cache.LookupNetIP(ctx, "example.com")
+	if up.calls.Load() != 1 {
+		t.Errorf("Expected a zero TTL to be raised to MinTTL")
+	}
+
+	// A day-long TTL is capped at MaxTTL
+	up.ttl = 24 * time.Hour
+	clock.advance(time.Hour)
+	cache.LookupNetIP(ctx, "example.com")
+	clock.advance(cache.MaxTTL + time.Second)
+	cache.LookupNetIP(ctx, "example.com")
+	if up.calls.Load() != 3 {
+		t.Errorf("Expected a long TTL to be capped at MaxTTL, got %d queries", up.calls.Load())
+	}
+}
+
+func TestDNSCache_NegativeCaching(t *testing.T) {
+	up := &fakeUpstream{answers: map[string][]netip.Addr{}}
+	cache, clock := newTestCache(up)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.LookupNetIP(ctx, "missing.example"); !isNotFound(err) {
+			t.Fatalf("Expected not found, got %v", err)
+		}
+	}
+	if up.calls.Load() != 1 {
+		t.Errorf("Expected the miss to be cached, got %d queries", up.calls.Load())
+	}
+
+	clock.advance(cache.NegativeTTL + time.Second)
+	cache.LookupNetIP(ctx, "missing.example")
+	if up.calls.Load() != 2 {
+		t.Errorf("Negative entry outlived NegativeTTL")
+	}
+
+	// Temporary failures are never cached
+	up.err = &net.DNSError{Err: "server misbehaving", Name: "flaky.example", IsTemporary: true}
+	cache.LookupNetIP(ctx, "flaky.example")
+	cache.LookupNetIP(ctx, "flaky.example")
+	if up.calls.Load() != 4 {
+		t.Errorf("Expected temporary failures to be retried, got %d queries", up.calls.Load())
+	}
+}
+
+func TestDNSCache_CollapsesConcurrentLookups(t *testing.T) {
+	up := &fakeUpstream{
+		answers: map[string][]netip.Addr{"example.com": addrs("93.184.216.34")},
+		ttl:     time.Minute,
+		release: make(chan struct{}),
+	}
+	cache, _ := newTestCache(up)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.LookupNetIP(context.Background(), "example.com")
+			errs <- err
+		}()
+	}
+	// Let the goroutines pile up behind the first query
+	time.Sleep(50 * time.Millisecond)
+	close(up.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if up.calls.Load() != 1 {
+		t.Errorf("Expected 1 upstream query for 50 callers, got %d", up.calls.Load())
+	}
+}
+
+func TestDNSCache_CallerCancelDoesNotFailOthers(t *testing.T) {
+	up := &fakeUpstream{
+		answers: map[string][]netip.Addr{"example.com": addrs("93.184.216.34")},
+		ttl:     time.Minute,
+		release: make(chan struct{}),
+	}
+	cache, _ := newTestCache(up)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := cache.LookupNetIP(ctx, "example.com")
+		done <- err
+	}()
+	other := make(chan error)
+	go func() {
+		_, err := cache.LookupNetIP(context.Background(), "example.com")
+		other <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled caller to return, got %v", err)
+	}
+	close(up.release)
+	if err := <-other; err != nil {
+		t.Errorf("Expected the other caller to get the answer, got %v", err)
+	}
+}
+
+func TestDNSCache_ReturnsCopies(t *testing.T) {
+	up := &fakeUpstream{answers: map[string][]netip.Addr{"example.com": addrs("93.184.216.34")}, ttl: time.Minute}
+	cache, _ := newTestCache(up)
+
+	ips, _ := cache.LookupNetIP(context.Background(), "example.com")
+	ips[0] = netip.MustParseAddr("127.0.0.1")
+	again, _ := cache.LookupNetIP(context.Background(), "example.com")
+	if again[0].String() != "93.184.216.34" {
+		t.Errorf("Caller modified the cached answer: %v", again)
+	}
+}
+
+func TestDNSCache_MaxEntries(t *testing.T) {
+	up := &fakeUpstream{answers: map[string][]netip.Addr{
+		"a.example": addrs("192.0.2.1"), "b.example": addrs("192.0.2.2"), "c.example": addrs("192.0.2.3"),
+	}, ttl: time.Minute}
+	cache, clock := newTestCache(up)
+	cache.MaxEntries = 2
+	ctx := context.Background()
+
+	cache.LookupNetIP(ctx, "a.example")
+	clock.advance(time.Second)
+	cache.LookupNetIP(ctx, "b.example")
+	cache.LookupNetIP(ctx, "c.example")
+
+	if len(cache.entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(cache.entries))
+	}
+	if _, ok := cache.entries["a.example"]; ok {
+		t.Error("Expected the entry expiring soonest to be evicted")
+	}
+}
+
+func TestSafeDialer_PinsValidatedIPs(t *testing.T) {
+	port := listen(t)
+
+	// A rebinding name that resolves to loopback is refused even when the answer comes from the cache
+	up := &fakeUpstream{answers: map[string][]netip.Addr{"rebind.example": addrs("127.0.0.1")}, ttl: time.Minute}
+	cache, _ := newTestCache(up)
+
+	strict := SafeDialer(&net.Dialer{}, &Policy{Resolver: cache})
+	for i := 0; i < 2; i++ {
+		if _, err := strict(context.Background(), "tcp", net.JoinHostPort("rebind.example", port)); !errors.Is(err, ErrBlockedIP) {
+			t.Errorf("Expected ErrBlockedIP, got %v", err)
+		}
+	}
+
+	// Another client sharing the cache applies its own policy to the same answer
+	local := SafeDialer(&net.Dialer{}, &Policy{AllowPrivate: true, Resolver: cache})
+	conn, err := local(context.Background(), "tcp", net.JoinHostPort("rebind.example", port))
+	if err != nil {
+		t.Fatalf("Expected the local client to connect, got %v", err)
+	}
+	conn.Close()
+
+	if up.calls.Load() != 1 {
+		t.Errorf("Expected every dial to share one lookup, got %d", up.calls.Load())
+	}
+}
+
+// serveDNS answers A queries with 192.0.2.7 and the given TTL, and AAAA queries with nothing
+func serveDNS(t *testing.T, ttl uint32) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true, RecursionAvailable: true})
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			if q.Type == dnsmessage.TypeA {
+				b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: ttl}, dnsmessage.AResource{A: [4]byte{192, 0, 2, 7}})
+			}
+			msg, _ := b.Finish()
+			pc.WriteTo(msg, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestSystemUpstream_ReadsTTL(t *testing.T) {
+	up := &SystemUpstream{Server: serveDNS(t, 42)}
+	ips, ttl, err := up.Lookup(context.Background(), "ttl.example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || ips[0].String() != "192.0.2.7" {
+		t.Errorf("Unexpected addresses %v", ips)
+	}
+	if ttl != 42*time.Second {
+		t.Errorf("Expected TTL 42s, got %v", ttl)
+	}
+}
+
+func TestTTLRecorder_TCPStream(t *testing.T) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	b.StartAnswers()
+	name := dnsmessage.MustNewName("example.com.")
+	b.AResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 300}, dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})
+	b.AResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 90}, dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}})
+	msg, _ := b.Finish()
+	framed := append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...)
+
+	// Deliver the framed message in small pieces as a TCP stream might
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		for i := 0; i < len(framed); i += 7 {
+			server.Write(framed[i:min(i+7, len(framed))])
+		}
+		server.Close()
+	}()
+
+	rec := &ttlRecorder{}
+	conn := &ttlConn{Conn: client, rec: rec}
+	buf := make([]byte, 5)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			break
+		}
+	}
+	if ttl, ok := rec.ttl(); !ok || ttl != 90*time.Second {
+		t.Errorf("Expected the lowest TTL 90s, got %v (%v)", ttl, ok)
+	}
+}
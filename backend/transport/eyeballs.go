@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// defaultAttemptDelay is how long a connection attempt runs before the next
+// address is tried alongside it (RFC 8305 section 5 recommends 250ms)
+const defaultAttemptDelay = 250 * time.Millisecond
+
+// interleave orders addresses alternating between IPv6 and IPv4, starting
+// with the family of the first address, as RFC 8305 section 4 describes
+func interleave(ips []netip.Addr) []netip.Addr {
+	if len(ips) == 0 {
+		return ips
+	}
+	var v6, v4 []netip.Addr
+	for _, ip := range ips {
+		if ip.Is6() && !ip.Is4In6() {
+			v6 = append(v6, ip)
+		} else {
+			v4 = append(v4, ip)
+		}
+	}
+	first, second := v6, v4
+	if ips[0].Is4() || ips[0].Is4In6() {
+		first, second = v4, v6
+	}
+	ordered := make([]netip.Addr, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+// matchesNetwork drops addresses of the wrong family for "tcp4" and "tcp6"
+func matchesNetwork(network string, ip netip.Addr) bool {
+	switch network {
+	case "tcp4", "udp4":
+		return ip.Is4() || ip.Is4In6()
+	case "tcp6", "udp6":
+		return ip.Is6() && !ip.Is4In6()
+	}
+	return true
+}
+
+// dialFunc connects to a single validated address
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialParallel races connections to already validated addresses. Attempts
+// start one at a time, each attemptDelay after the previous one or as soon as
+// it fails, and the first to connect wins; the rest are cancelled and closed.
+// A negative delay dials the addresses strictly one after another.
+func dialParallel(ctx context.Context, dial dialFunc, delay time.Duration, network string, ips []netip.Addr, port string) (net.Conn, error) {
+	if delay == 0 {
+		delay = defaultAttemptDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		conn net.Conn
+		err  error
+	}
+	// Buffered so losing attempts never block after we return
+	results := make(chan attempt, len(ips))
+
+	next, pending := 0, 0
+	start := func() {
+		ip := ips[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+			results <- attempt{conn, err}
+		}()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	if delay < 0 {
+		timer.Stop()
+	}
+
+	var firstErr error
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				go func(n int) {
+					for range n {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(ips) {
+				start()
+				if delay > 0 {
+					timer.Reset(delay)
+				}
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, firstErr
+}
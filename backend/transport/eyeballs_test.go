@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func addrs(list ...string) []netip.Addr {
+	ips := make([]netip.Addr, len(list))
+	for i, s := range list {
+		ips[i] = netip.MustParseAddr(s)
+	}
+	return ips
+}
+
+func TestInterleave(t *testing.T) {
+	tests := []struct {
+		in, want []netip.Addr
+	}{
+		{addrs("2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2"), addrs("2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2")},
+		{addrs("192.0.2.1", "192.0.2.2", "2001:db8::1"), addrs("192.0.2.1", "2001:db8::1", "192.0.2.2")},
+		{addrs("192.0.2.1", "192.0.2.2"), addrs("192.0.2.1", "192.0.2.2")},
+		{addrs("::ffff:192.0.2.1", "2001:db8::1"), addrs("::ffff:192.0.2.1", "2001:db8::1")},
+	}
+	for _, tc := range tests {
+		if got := interleave(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("interleave(%v) = %v; want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMatchesNetwork(t *testing.T) {
+	v4, v6, mapped := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("::ffff:192.0.2.1")
+	if !matchesNetwork("tcp", v4) || !matchesNetwork("tcp", v6) {
+		t.Error("tcp should accept both families")
+	}
+	if !matchesNetwork("tcp4", v4) || !matchesNetwork("tcp4", mapped) || matchesNetwork("tcp4", v6) {
+		t.Error("tcp4 should only accept IPv4")
+	}
+	if !matchesNetwork("tcp6", v6) || matchesNetwork("tcp6", mapped) || matchesNetwork("tcp6", v4) {
+		t.Error("tcp6 should only accept IPv6")
+	}
+}
+
+func listen(t *testing.T) (port string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ = net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func TestDialParallel_FallsBackAfterFailure(t *testing.T) {
+	port := listen(t)
+
+	// Nothing listens on 127.0.0.2, so the first attempt is refused and the second starts at once
+	dialer := &net.Dialer{Timeout: time.Second, FallbackDelay: time.Hour}
+	start := time.Now()
+	conn, err := dialParallel(context.Background(), dialer.DialContext, dialer.FallbackDelay, "tcp", addrs("127.0.0.2", "127.0.0.1"), port)
+	if err != nil {
+		t.Fatalf("Expected the second address to connect, got %v", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().(*net.TCPAddr).IP.String() != "127.0.0.1" {
+		t.Errorf("Connected to %v; want 127.0.0.1", conn.RemoteAddr())
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Fallback waited for the attempt delay instead of starting on failure")
+	}
+}
+
+func TestDialParallel_RacesHangingAttempt(t *testing.T) {
+	port := listen(t)
+
+	// The first attempt never completes; the second starts after the attempt delay and wins
+	var mu sync.Mutex
+	var dialed []string
+	dialer := &net.Dialer{
+		Timeout:       5 * time.Second,
+		FallbackDelay: 20 * time.Millisecond,
+		Control: func(network, address string, c syscall.RawConn) error {
+			mu.Lock()
+			dialed = append(dialed, address)
+			mu.Unlock()
+			return nil
+		},
+	}
+	hang := addrs("192.0.2.1")[0] // TEST-NET-1 is not routed
+	start := time.Now()
+	conn, err := dialParallel(context.Background(), dialer.DialContext, dialer.FallbackDelay, "tcp", []netip.Addr{hang, netip.MustParseAddr("127.0.0.1")}, port)
+	if err != nil {
+		t.Fatalf("Expected the racing attempt to connect, got %v", err)
+	}
+	defer conn.Close()
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("The hanging attempt was not raced")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) != 2 {
+		t.Errorf("Expected both addresses to be attempted, got %v", dialed)
+	}
+}
+
+func TestDialParallel_AllFail(t *testing.T) {
+	dialer := &net.Dialer{Timeout: time.Second}
+	// Port 1 on loopback is closed
+	_, err := dialParallel(context.Background(), dialer.DialContext, dialer.FallbackDelay, "tcp", addrs("127.0.0.2", "127.0.0.3"), "1")
+	if err == nil {
+		t.Fatal("Expected an error when every address is refused")
+	}
+}
+
+func TestSafeDialer_SkipsBlockedAddresses(t *testing.T) {
+	port := listen(t)
+
+	// Loopback is allowed, but the denied addresses among it must never be dialed
+	deny, _ := ParsePrefixes("127.0.0.2")
+	policy := &Policy{AllowPrivate: true, DenyCIDRs: deny}
+	safeDial := SafeDialer(&net.Dialer{Timeout: time.Second}, policy)
+
+	conn, err := safeDial(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("Expected the allowed address to connect, got %v", err)
+	}
+	conn.Close()
+
+	if _, err := safeDial(context.Background(), "tcp", net.JoinHostPort("127.0.0.2", port)); !errors.Is(err, ErrBlockedIP) {
+		t.Errorf("Expected ErrBlockedIP for the denied address, got %v", err)
+	}
+	if _, err := safeDial(context.Background(), "tcp6", net.JoinHostPort("127.0.0.1", port)); !errors.Is(err, ErrBlockedIP) {
+		t.Errorf("Expected no usable address for tcp6, got %v", err)
+	}
+}
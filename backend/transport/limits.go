@@ -0,0 +1,256 @@
+package transport
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// LimitError is returned when a response breaks one of the Limits. Code is
+// stable and distinct for each limit, for logs and metrics.
+type LimitError struct {
+	Code string
+	msg  string
+}
+
+func (e *LimitError) Error() string { return e.msg }
+
+var (
+	// ErrHeadersTooLarge is returned when the response headers exceed MaxHeaderBytes
+	ErrHeadersTooLarge = &LimitError{Code: "response_headers_too_large", msg: "response headers exceed the size limit"}
+	// ErrBodyTooLarge is returned once more than MaxBodyBytes of decoded body have been read
+	ErrBodyTooLarge = &LimitError{Code: "response_body_too_large", msg: "response body exceeds the size limit"}
+	// ErrTransferTooSlow is returned when the body arrives slower than MinBytesPerSecond
+	ErrTransferTooSlow = &LimitError{Code: "transfer_too_slow", msg: "response body is arriving too slowly"}
+	// ErrDecompressionRatio is returned when a compressed body expands more than MaxDecompressionRatio
+	ErrDecompressionRatio = &LimitError{Code: "decompression_ratio_exceeded", msg: "compressed response expands beyond the ratio limit"}
+)
+
+// LimitCode returns the code of the limit err broke, or "" if it isn't a LimitError
+func LimitCode(err error) string {
+	var le *LimitError
+	if errors.As(err, &le) {
+		return le.Code
+	}
+	return ""
+}
+
+// decompressionSlack is how much a body may expand before the ratio is
+// enforced, so small, highly repetitive pages aren't mistaken for bombs
+const decompressionSlack = 64 * 1024
+
+// Limits bound what a single response may cost us. Bodies are decompressed
+// here rather than by http.Transport, so the decompression ratio can be
+// measured; gzip and brotli are accepted. A zero field disables that limit.
+type Limits struct {
+	// MaxHeaderBytes bounds the size of the response headers
+	MaxHeaderBytes int64
+	// MaxBodyBytes bounds the decoded body, however much of it the caller reads
+	MaxBodyBytes int64
+	// MinBytesPerSecond is the slowest average rate a body may arrive at once RateGracePeriod has passed
+	MinBytesPerSecond int64
+	RateGracePeriod   time.Duration
+	// MaxDecompressionRatio bounds decoded bytes per compressed byte
+	MaxDecompressionRatio int64
+}
+
+// DefaultLimits are generous for web pages and API responses and stop
+// anything built to exhaust memory or hold connections open
+func DefaultLimits() *Limits {
+	return &Limits{
+		MaxHeaderBytes:        64 * 1024,
+		MaxBodyBytes:          5 * 1024 * 1024,
+		MinBytesPerSecond:     1024,
+		RateGracePeriod:       2 * time.Second,
+		MaxDecompressionRatio: 100,
+	}
+}
+
+// wrap returns a RoundTripper that enforces the limits on responses from base,
+// which must have compression disabled
+func (l *Limits) wrap(base http.RoundTripper) http.RoundTripper {
+	if l == nil {
+		return base
+	}
+	return &limitedTransport{limits: l, base: base}
+}
+
+type limitedTransport struct {
+	limits *Limits
+	base   http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Ask for compression the way http.Transport would: not for ranges, and
+	// not when the caller has chosen encodings itself
+	decode := req.Method != http.MethodHead && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == ""
+	if decode {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip, br")
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		if isHeaderLimitErr(err) {
+			return nil, fmt.Errorf("%w: %v", ErrHeadersTooLarge, err)
+		}
+		return nil, err
+	}
+
+	body := newLimitedBody(resp.Body, t.limits)
+	resp.Body = body
+	if !decode {
+		return resp, nil
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding != "gzip" && encoding != "br" {
+		return resp, nil
+	}
+	body.encoding = encoding
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// isHeaderLimitErr recognises http.Transport's MaxResponseHeaderBytes errors,
+// which are untyped, over HTTP/1 and HTTP/2
+func isHeaderLimitErr(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "server response headers exceeded") ||
+		strings.Contains(msg, "response header list larger than advertised limit")
+}
+
+// limitedBody counts wire and decoded bytes as they are read. A watchdog
+// closes the connection as soon as the wire rate falls below the minimum,
+// so a body that stops arriving can't leave Read blocked.
+type limitedBody struct {
+	raw      io.ReadCloser
+	limits   *Limits
+	encoding string // "gzip" or "br" when the body is decoded here
+	decoder  io.Reader
+
+	start   time.Time
+	decoded int64
+
+	mu    sync.Mutex
+	wire  int64
+	timer *time.Timer
+	err   error // set by the watchdog or a broken limit, and returned from then on
+}
+
+func newLimitedBody(raw io.ReadCloser, limits *Limits) *limitedBody {
+	b := &limitedBody{raw: raw, limits: limits, start: time.Now()}
+	if limits.MinBytesPerSecond > 0 {
+		b.timer = time.AfterFunc(b.untilTooSlow(), b.tooSlow)
+	}
+	return b
+}
+
+// untilTooSlow is how long the body can go without more bytes before its
+// average rate since the start drops below the minimum
+func (b *limitedBody) untilTooSlow() time.Duration {
+	allowed := b.limits.RateGracePeriod + time.Duration(float64(b.wire)/float64(b.limits.MinBytesPerSecond)*float64(time.Second))
+	return time.Until(b.start.Add(allowed))
+}
+
+func (b *limitedBody) tooSlow() {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = ErrTransferTooSlow
+	}
+	b.mu.Unlock()
+	b.raw.Close()
+}
+
+// readWire reads the raw body, counting bytes and rearming the watchdog
+func (b *limitedBody) readWire(p []byte) (int, error) {
+	n, err := b.raw.Read(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.wire += int64(n)
+	if b.err != nil {
+		return n, b.err
+	}
+	if b.timer != nil {
+		if err != nil {
+			b.timer.Stop()
+		} else if n > 0 {
+			b.timer.Reset(b.untilTooSlow())
+		}
+	}
+	return n, err
+}
+
+type wireReader struct{ b *limitedBody }
+
+func (r wireReader) Read(p []byte) (int, error) { return r.b.readWire(p) }
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	err := b.err
+	b.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	src := io.Reader(wireReader{b})
+	if b.encoding != "" {
+		if b.decoder == nil {
+			if b.decoder, err = b.newDecoder(); err != nil {
+				return 0, b.fail(err)
+			}
+		}
+		src = b.decoder
+	}
+
+	n, err := src.Read(p)
+	b.decoded += int64(n)
+
+	l := b.limits
+	if l.MaxBodyBytes > 0 && b.decoded > l.MaxBodyBytes {
+		return n, b.fail(ErrBodyTooLarge)
+	}
+	if b.encoding != "" && l.MaxDecompressionRatio > 0 && b.decoded > decompressionSlack {
+		b.mu.Lock()
+		wire := b.wire
+		b.mu.Unlock()
+		if b.decoded > l.MaxDecompressionRatio*max(wire, 1) {
+			return n, b.fail(ErrDecompressionRatio)
+		}
+	}
+	return n, err
+}
+
+func (b *limitedBody) newDecoder() (io.Reader, error) {
+	if b.encoding == "br" {
+		return brotli.NewReader(wireReader{b}), nil
+	}
+	return gzip.NewReader(wireReader{b})
+}
+
+// fail records a broken limit so every later Read returns it too
+func (b *limitedBody) fail(err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	return b.err
+}
+
+func (b *limitedBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	return b.raw.Close()
+}
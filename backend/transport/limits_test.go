@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func limitedClient(l *Limits) *http.Client {
+	policy := LocalPolicy()
+	policy.Limits = l
+	return &http.Client{Transport: NewSafeTransport(policy)}
+}
+
+func readAll(t *testing.T, client *http.Client, target string) ([]byte, error) {
+	t.Helper()
+	resp, err := client.Get(target)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func TestLimits_Decompresses(t *testing.T) {
+	page := strings.Repeat("<p>Hello, world</p>", 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted := r.Header.Get("Accept-Encoding")
+		switch {
+		case strings.Contains(accepted, "br"):
+			w.Header().Set("Content-Encoding", "br")
+			bw := brotli.NewWriter(w)
+			io.WriteString(bw, page)
+			bw.Close()
+		case strings.Contains(accepted, "gzip"):
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			io.WriteString(gw, page)
+			gw.Close()
+		default:
+			io.WriteString(w, page)
+		}
+	}))
+	defer ts.Close()
+
+	body, err := readAll(t, limitedClient(DefaultLimits()), ts.URL)
+	if err != nil || string(body) != page {
+		t.Fatalf("Expected the decoded page, got %d bytes (%v)", len(body), err)
+	}
+
+	// Callers that pick their own encodings get the body as sent
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := limitedClient(DefaultLimits()).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected the gzip body untouched, got %q", resp.Header.Get("Content-Encoding"))
+	}
+}
+
+func TestLimits_Errors(t *testing.T) {
+	var bomb bytes.Buffer
+	gw := gzip.NewWriter(&bomb)
+	gw.Write(make([]byte, 1024*1024))
+	gw.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Padding", strings.Repeat("a", 8*1024))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 64*1024))
+	})
+	mux.HandleFunc("/bomb", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(bomb.Bytes())
+	})
+	mux.HandleFunc("/trickle", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 20; i++ {
+			w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	limits := &Limits{
+		MaxHeaderBytes:        4 * 1024,
+		MaxBodyBytes:          32 * 1024,
+		MinBytesPerSecond:     1024,
+		RateGracePeriod:       200 * time.Millisecond,
+		MaxDecompressionRatio: 100,
+	}
+	tests := []struct {
+		path string
+		want error
+		code string
+	}{
+		{"/headers", ErrHeadersTooLarge, "response_headers_too_large"},
+		{"/large", ErrBodyTooLarge, "response_body_too_large"},
+		{"/bomb", ErrDecompressionRatio, "decompression_ratio_exceeded"},
+		{"/trickle", ErrTransferTooSlow, "transfer_too_slow"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			limits := *limits
+			if tc.path == "/bomb" {
+				limits.MaxBodyBytes = 0 // So the ratio is what trips
+			}
+			start := time.Now()
+			_, err := readAll(t, limitedClient(&limits), ts.URL+tc.path)
+			if !errors.Is(err, tc.want) || LimitCode(err) != tc.code {
+				t.Errorf("Expected %s, got %v", tc.code, err)
+			}
+			if time.Since(start) > 800*time.Millisecond {
+				t.Errorf("Expected the limit to stop the transfer early, took %v", time.Since(start))
+			}
+		})
+	}
+
+	// Zero fields disable a limit
+	if _, err := readAll(t, limitedClient(&Limits{}), ts.URL+"/large"); err != nil {
+		t.Errorf("Expected no limits to apply, got %v", err)
+	}
+	if LimitCode(errors.New("other")) != "" {
+		t.Error("Expected no code for other errors")
+	}
+}
@@ -0,0 +1,305 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrBlockedIP is returned when every address a host resolves to is refused
+	ErrBlockedIP = errors.New("blocked: resolves to private/local IP")
+	// ErrBlockedHost is returned for hostnames the policy refuses
+	ErrBlockedHost = errors.New("blocked: host not allowed by egress policy")
+	// ErrBlockedPort is returned for destination ports the policy refuses
+	ErrBlockedPort = errors.New("blocked: port not allowed by egress policy")
+)
+
+// specialPurpose are the IANA special-purpose and non-routable ranges no
+// fetch should ever reach, from the IPv4 and IPv6 Special-Purpose Address Registries
+var specialPurpose = mustPrefixes(
+	// IPv4
+	"0.0.0.0/8",       // "This network"
+	"10.0.0.0/8",      // RFC1918
+	"100.64.0.0/10",   // RFC6598 CGNAT shared address space
+	"127.0.0.0/8",     // Loopback
+	"169.254.0.0/16",  // Link-local, including cloud metadata at 169.254.169.254
+	"172.16.0.0/12",   // RFC1918
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1 documentation
+	"192.31.196.0/24", // AS112-v4
+	"192.52.193.0/24", // AMT
+	"192.88.99.0/24",  // Deprecated 6to4 relay anycast
+	"192.168.0.0/16",  // RFC1918
+	"192.175.48.0/24", // Direct delegation AS112
+	"198.18.0.0/15",   // Benchmarking
+	"198.51.100.0/24", // TEST-NET-2 documentation
+	"203.0.113.0/24",  // TEST-NET-3 documentation
+	"224.0.0.0/4",     // Multicast
+	"240.0.0.0/4",     // Reserved, including broadcast 255.255.255.255
+	// IPv6
+	"::/128",         // Unspecified
+	"::1/128",        // Loopback
+	"64:ff9b:1::/48", // Local-use NAT64
+	"100::/64",       // Discard-only
+	"2001::/23",      // IETF protocol assignments, including Teredo
+	"2001:db8::/32",  // Documentation
+	"3fff::/20",      // Documentation
+	"5f00::/16",      // Segment routing SIDs
+	"fc00::/7",       // Unique local
+	"fe80::/10",      // Link-local
+	"fec0::/10",      // Deprecated site-local
+	"ff00::/8",       // Multicast
+)
+
+// Prefixes that embed an IPv4 address, which is checked in their place
+var (
+	nat64Prefix      = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFourPrefix  = netip.MustParsePrefix("2002::/16")
+	ipv4CompatPrefix = netip.MustParsePrefix("::/96")
+)
+
+func mustPrefixes(cidrs ...string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, len(cidrs))
+	for i, c := range cidrs {
+		prefixes[i] = netip.MustParsePrefix(c)
+	}
+	return prefixes
+}
+
+// Policy decides which destinations a client may connect to. Each client
+// carries its own policy; the zero value blocks the special-purpose ranges
+// and allows any public address, host and port.
+//
+// Addresses are evaluated in order: DenyCIDRs, then AllowCIDRs, then the
+// special-purpose ranges unless AllowPrivate is set. Hostnames are refused
+// when they match DenyHosts or, if AllowHosts is non-empty, when they match
+// none of its patterns. Patterns are exact hostnames or "*.example.com",
+// which matches every subdomain of example.com but not example.com itself.
+type Policy struct {
+	AllowCIDRs []netip.Prefix
+	DenyCIDRs  []netip.Prefix
+	AllowHosts []string
+	DenyHosts  []string
+	// Ports restricts destination ports; empty allows any
+	Ports []int
+	// AllowPrivate lifts the special-purpose range block; only for tests and local development
+	AllowPrivate bool
+	// Proxy routes connections through upstream proxies; nil connects directly
+	Proxy *ProxyConfig
+	// Resolver looks up hostnames; nil uses SharedDNSCache
+	Resolver Resolver
+	// Politeness limits per-origin load for clients built on this policy; nil imposes no limits.
+	// API clients opt out with WithoutPoliteness.
+	Politeness *Politeness
+	// Limits bound response sizes, transfer rate and decompression; nil leaves responses unchecked
+	Limits *Limits
+	// Retry resends idempotent requests after transient failures; nil sends each request once
+	Retry *Retry
+	// Audit receives a record of every request made under this policy; nil keeps no record
+	Audit AuditSink
+}
+
+// DefaultPolicy blocks internal and special-purpose destinations
+func DefaultPolicy() *Policy {
+	return &Policy{Limits: DefaultLimits(), Retry: DefaultRetry()}
+}
+
+// LocalPolicy allows every destination, including loopback; for tests against local servers
+func LocalPolicy() *Policy {
+	return &Policy{AllowPrivate: true, Limits: DefaultLimits(), Retry: DefaultRetry()}
+}
+
+// WithoutPoliteness returns a copy of the policy without per-origin limits,
+// for clients of authenticated APIs whose quota is ours rather than a site's
+func (p *Policy) WithoutPoliteness() *Policy {
+	c := *p
+	c.Politeness = nil
+	return &c
+}
+
+func (p *Policy) resolver() Resolver {
+	if p.Resolver != nil {
+		return p.Resolver
+	}
+	return SharedDNSCache
+}
+
+// CheckPort refuses ports outside the allowed list
+func (p *Policy) CheckPort(port int) error {
+	if len(p.Ports) == 0 {
+		return nil
+	}
+	for _, allowed := range p.Ports {
+		if port == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrBlockedPort, port)
+}
+
+// CheckHost applies the host patterns to a hostname. With AllowHosts set, an
+// IP literal is refused unless AllowHosts lists it or AllowCIDRs covers it;
+// its address is otherwise left to CheckIP.
+func (p *Policy) CheckHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range p.DenyHosts {
+		if matchHost(pattern, host) {
+			return fmt.Errorf("%w: %s", ErrBlockedHost, host)
+		}
+	}
+	if len(p.AllowHosts) == 0 {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if p.allowsLiteral(ip) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrBlockedHost, host)
+	}
+	for _, pattern := range p.AllowHosts {
+		if matchHost(pattern, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrBlockedHost, host)
+}
+
+// allowsLiteral reports whether an allow-list policy names the IP literal,
+// as an exact AllowHosts entry or within AllowCIDRs; wildcard patterns never match it
+func (p *Policy) allowsLiteral(ip netip.Addr) bool {
+	ip = ip.WithZone("")
+	for _, pattern := range p.AllowHosts {
+		if allowed, err := netip.ParseAddr(strings.TrimSpace(pattern)); err == nil && allowed.WithZone("") == ip {
+			return true
+		}
+	}
+	for _, prefix := range p.AllowCIDRs {
+		if prefix.Contains(ip.Unmap()) || prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHost(pattern, host string) bool {
+	pattern = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), ".")
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// CheckIP refuses addresses in denied or special-purpose ranges. IPv4-mapped,
+// IPv4-compatible, NAT64 and 6to4 addresses are judged by the IPv4 address
+// they embed as well, so ::ffff:127.0.0.1 or 64:ff9b::a00:1 can't sneak past.
+func (p *Policy) CheckIP(ip netip.Addr) error {
+	if !ip.IsValid() {
+		return ErrBlockedIP
+	}
+	ip = ip.WithZone("")
+	for _, addr := range candidates(ip) {
+		if err := p.checkAddr(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// candidates returns the address followed by any IPv4 address embedded in it
+func candidates(ip netip.Addr) []netip.Addr {
+	if ip.Is4In6() {
+		return []netip.Addr{ip.Unmap()}
+	}
+	addrs := []netip.Addr{ip}
+	if !ip.Is6() {
+		return addrs
+	}
+	b := ip.As16()
+	switch {
+	case nat64Prefix.Contains(ip):
+		addrs = append(addrs, netip.AddrFrom4([4]byte(b[12:16])))
+	case sixToFourPrefix.Contains(ip):
+		addrs = append(addrs, netip.AddrFrom4([4]byte(b[2:6])))
+	case ipv4CompatPrefix.Contains(ip) && ip != netip.IPv6Unspecified() && ip != netip.IPv6Loopback():
+		addrs = append(addrs, netip.AddrFrom4([4]byte(b[12:16])))
+	}
+	return addrs
+}
+
+func (p *Policy) checkAddr(ip netip.Addr) error {
+	for _, prefix := range p.DenyCIDRs {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: %s is denied", ErrBlockedIP, ip)
+		}
+	}
+	for _, prefix := range p.AllowCIDRs {
+		if prefix.Contains(ip) {
+			return nil
+		}
+	}
+	if p.AllowPrivate {
+		return nil
+	}
+	for _, prefix := range specialPurpose {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: %s is in %s", ErrBlockedIP, ip, prefix)
+		}
+	}
+	return nil
+}
+
+// ParsePrefixes parses a comma-separated list of CIDRs; bare addresses become single-host prefixes
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ParsePorts parses a comma-separated list of ports
+func ParsePorts(list string) ([]int, error) {
+	var ports []int
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		port, err := strconv.Atoi(s)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", s)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// ParseHosts splits a comma-separated list of host patterns
+func ParseHosts(list string) []string {
+	var hosts []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			hosts = append(hosts, s)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,256 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrOriginBackoff is returned when an origin's Retry-After runs past the request's deadline
+var ErrOriginBackoff = errors.New("origin asked us to retry later")
+
+// Politeness limits how hard we hit any single origin: at most MaxConcurrent
+// requests in flight and a steady request rate per host, with requests over
+// either limit queued rather than refused. A 429 or 503 response holds back
+// every request to that origin until its Retry-After has passed.
+//
+// One Politeness should be shared by every client so the limits apply to the
+// process as a whole; a nil *Politeness imposes no limits.
+type Politeness struct {
+	// MaxConcurrent is the number of requests in flight per origin; zero means unlimited
+	MaxConcurrent int
+	// DefaultRetryAfter applies to 429 and 503 responses without a usable Retry-After
+	DefaultRetryAfter time.Duration
+	// MaxRetryAfter caps how long an origin can make us wait
+	MaxRetryAfter time.Duration
+
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	origins map[string]*origin
+
+	requests  atomic.Int64
+	waited    atomic.Int64
+	throttled atomic.Int64
+	waitTotal atomic.Int64
+	waitMax   atomic.Int64
+}
+
+type origin struct {
+	slots    chan struct{}
+	limiter  *rate.Limiter
+	retryAt  time.Time
+	lastSeen time.Time
+}
+
+// PolitenessStats reports how much politeness has slowed outbound requests
+type PolitenessStats struct {
+	Requests  int64         // requests that went through the limits
+	Waited    int64         // requests that had to queue
+	Throttled int64         // 429 and 503 responses seen
+	TotalWait time.Duration // time spent queueing, summed over all requests
+	MaxWait   time.Duration // longest single wait
+}
+
+// NewPoliteness allows maxConcurrent requests in flight and rpm requests per
+// minute (with bursts of burst) to each origin; zero disables either limit
+func NewPoliteness(maxConcurrent, rpm, burst int) *Politeness {
+	limit := rate.Inf
+	if rpm > 0 {
+		limit = rate.Limit(rpm) / 60.0
+	}
+	return &Politeness{
+		MaxConcurrent:     maxConcurrent,
+		DefaultRetryAfter: 5 * time.Second,
+		MaxRetryAfter:     time.Minute,
+		limit:             limit,
+		burst:             max(burst, 1),
+		origins:           make(map[string]*origin),
+	}
+}
+
+// Stats returns the wait metrics collected so far
+func (p *Politeness) Stats() PolitenessStats {
+	return PolitenessStats{
+		Requests:  p.requests.Load(),
+		Waited:    p.waited.Load(),
+		Throttled: p.throttled.Load(),
+		TotalWait: time.Duration(p.waitTotal.Load()),
+		MaxWait:   time.Duration(p.waitMax.Load()),
+	}
+}
+
+// CleanupBackground starts a goroutine that forgets idle origins
+func (p *Politeness) CleanupBackground(interval time.Duration, expiry time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			p.mu.Lock()
+			for host, o := range p.origins {
+				// Keep origins that are busy or still backing off
+				if time.Since(o.lastSeen) > expiry && len(o.slots) == 0 && time.Now().After(o.retryAt) {
+					delete(p.origins, host)
+				}
+			}
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// Wrap returns a RoundTripper that applies the limits in front of base
+func (p *Politeness) Wrap(base http.RoundTripper) http.RoundTripper {
+	if p == nil {
+		return base
+	}
+	return &politeTransport{politeness: p, base: base}
+}
+
+func (p *Politeness) origin(host string) *origin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o, ok := p.origins[host]
+	if !ok {
+		o = &origin{limiter: rate.NewLimiter(p.limit, p.burst)}
+		if p.MaxConcurrent > 0 {
+			o.slots = make(chan struct{}, p.MaxConcurrent)
+		}
+		p.origins[host] = o
+	}
+	o.lastSeen = time.Now()
+	return o
+}
+
+// backOff holds requests to the origin until retryAt
+func (p *Politeness) backOff(o *origin, retryAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if retryAt.After(o.retryAt) {
+		o.retryAt = retryAt
+	}
+}
+
+func (p *Politeness) recordWait(d time.Duration) {
+	p.requests.Add(1)
+	if d < time.Millisecond {
+		return
+	}
+	p.waited.Add(1)
+	p.waitTotal.Add(int64(d))
+	for {
+		cur := p.waitMax.Load()
+		if int64(d) <= cur || p.waitMax.CompareAndSwap(cur, int64(d)) {
+			return
+		}
+	}
+}
+
+// retryAfter reads a Retry-After header in seconds or as an HTTP date, clamped to MaxRetryAfter
+func (p *Politeness) retryAfter(h string, now time.Time) time.Duration {
+	d := p.DefaultRetryAfter
+	h = strings.TrimSpace(h)
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(h); err == nil {
+		d = max(t.Sub(now), 0)
+	}
+	return min(d, p.MaxRetryAfter)
+}
+
+// usableRetryAfter reports whether a Retry-After header can be read
+func usableRetryAfter(h string) bool {
+	h = strings.TrimSpace(h)
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return true
+	}
+	_, err := http.ParseTime(h)
+	return err == nil
+}
+
+type politeTransport struct {
+	politeness *Politeness
+	base       http.RoundTripper
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.politeness
+	host := strings.ToLower(req.URL.Host)
+	o := p.origin(host)
+	ctx := req.Context()
+	start := time.Now()
+
+	p.mu.Lock()
+	retryAt := o.retryAt
+	p.mu.Unlock()
+	if wait := time.Until(retryAt); wait > 0 {
+		// No point queueing for a slot we can't use before the deadline
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(retryAt) {
+			return nil, fmt.Errorf("%w: %s for another %s", ErrOriginBackoff, host, wait.Round(time.Second))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	if err := o.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	release := func() {}
+	if o.slots != nil {
+		select {
+		case o.slots <- struct{}{}:
+			release = func() { <-o.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	p.recordWait(time.Since(start))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		p.throttled.Add(1)
+		now := time.Now()
+		// Without a Retry-After of its own, a response the retry layer will
+		// resend is left to its backoff: holding the origin for
+		// DefaultRetryAfter would outlast the deadline and stop the retry.
+		// The final attempt still holds it.
+		h := resp.Header.Get("Retry-After")
+		if usableRetryAfter(h) || !retryPending(req) {
+			p.backOff(o, now.Add(p.retryAfter(h, now)))
+		}
+	}
+
+	// The slot stays taken until the body has been read and closed
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
@@ -0,0 +1,195 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func politeClient(p *Politeness) *http.Client {
+	return &http.Client{Transport: p.Wrap(&http.Transport{})}
+}
+
+func get(t *testing.T, client *http.Client, ctx context.Context, target string) (*http.Response, error) {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	resp, err := client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestPoliteness_LimitsConcurrencyPerOrigin(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		for {
+			cur := peak.Load()
+			if n <= cur || peak.CompareAndSwap(cur, n) {
+				break
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		inFlight.Add(-1)
+	})
+	busy := httptest.NewServer(handler)
+	defer busy.Close()
+
+	p := NewPoliteness(2, 0, 0)
+	client := politeClient(p)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := get(t, client, context.Background(), busy.URL); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak.Load() > 2 {
+		t.Errorf("Expected at most 2 requests in flight, saw %d", peak.Load())
+	}
+	stats := p.Stats()
+	if stats.Requests != 8 || stats.Waited == 0 || stats.TotalWait == 0 || stats.MaxWait == 0 {
+		t.Errorf("Expected queueing to show in the stats, got %+v", stats)
+	}
+}
+
+func TestPoliteness_RateLimitsPerOrigin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// 600 per minute is one every 100ms after the first
+	p := NewPoliteness(0, 600, 1)
+	client := politeClient(p)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := get(t, client, context.Background(), ts.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("Expected requests to be spaced out, took %v", elapsed)
+	}
+	if p.Stats().Waited < 2 {
+		t.Errorf("Expected 2 queued requests, got %+v", p.Stats())
+	}
+}
+
+func TestPoliteness_HonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	p := NewPoliteness(0, 0, 0)
+	client := politeClient(p)
+
+	resp, err := get(t, client, context.Background(), ts.URL)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected a 429, got %v (%v)", resp, err)
+	}
+
+	// A request that can't outlast the backoff fails fast without reaching the origin
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := get(t, client, ctx, ts.URL); !errors.Is(err, ErrOriginBackoff) {
+		t.Errorf("Expected ErrOriginBackoff, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("The origin was contacted during its backoff")
+	}
+
+	// One with time to spare waits it out
+	start := time.Now()
+	resp, err = get(t, client, context.Background(), ts.URL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected success after the backoff, got %v (%v)", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond {
+		t.Errorf("Expected to wait for Retry-After, waited %v", elapsed)
+	}
+	if p.Stats().Throttled != 1 {
+		t.Errorf("Expected 1 throttled response, got %+v", p.Stats())
+	}
+}
+
+func TestPoliteness_SlotHeldUntilBodyClosed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "body")
+	}))
+	defer ts.Close()
+
+	client := politeClient(NewPoliteness(1, 0, 0))
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := get(t, client, ctx, ts.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the second request to queue behind the open body, got %v", err)
+	}
+
+	// Other origins have their own slots
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := get(t, client, ctx, other.URL); err != nil {
+		t.Errorf("Expected another origin to be unaffected, got %v", err)
+	}
+
+	resp.Body.Close()
+	if _, err := get(t, client, context.Background(), ts.URL); err != nil {
+		t.Errorf("Expected the slot to be free once the body was closed, got %v", err)
+	}
+}
+
+func TestPoliteness_RetryAfterParsing(t *testing.T) {
+	p := NewPoliteness(0, 0, 0)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", p.DefaultRetryAfter},
+		{"garbage", p.DefaultRetryAfter},
+		{"0", 0},
+		{"12", 12 * time.Second},
+		{"3600", p.MaxRetryAfter},
+		{now.Add(20 * time.Second).Format(http.TimeFormat), 20 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tc := range tests {
+		if got := p.retryAfter(tc.header, now); got != tc.want {
+			t.Errorf("retryAfter(%q) = %v; want %v", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestPoliteness_NilIsUnlimited(t *testing.T) {
+	var p *Politeness
+	base := &http.Transport{}
+	if p.Wrap(base) != http.RoundTripper(base) {
+		t.Error("A nil Politeness should return the base transport")
+	}
+}
@@ -0,0 +1,335 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestProxyConfig_ProxyFor(t *testing.T) {
+	corp, _ := url.Parse("http://proxy.corp:3128")
+	socks, _ := url.Parse("socks5://socks.corp:1080")
+	config := &ProxyConfig{
+		Default: corp,
+		Rules: []ProxyRule{
+			{Pattern: "*.internal.example"},
+			{Pattern: "api.github.com", Proxy: socks},
+			{Pattern: "*.github.com"},
+		},
+	}
+
+	tests := []struct {
+		host string
+		want *url.URL
+	}{
+		{"example.com", corp},
+		{"svc.internal.example", nil},
+		{"API.GitHub.com.", socks},
+		{"raw.github.com", nil},
+		{"github.com", corp},
+	}
+	for _, tc := range tests {
+		if got := config.ProxyFor(tc.host); got != tc.want {
+			t.Errorf("ProxyFor(%s) = %v; want %v", tc.host, got, tc.want)
+		}
+	}
+
+	var none *ProxyConfig
+	if none.ProxyFor("example.com") != nil {
+		t.Error("A nil config should connect directly")
+	}
+}
+
+func TestParseProxyRules(t *testing.T) {
+	rules, err := ParseProxyRules("*.corp=direct, *.github.com=socks5://u:p@socks:1080 ,*=http://proxy:3128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 || rules[0].Proxy != nil || rules[1].Proxy.Scheme != "socks5" || rules[2].Pattern != "*" {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+	for _, bad := range []string{"example.com", "=http://proxy", "a.com=ftp://proxy", "a.com=http://"} {
+		if _, err := ParseProxyRules(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+	if config, err := ProxyFromEnvironment(); config != nil || err != nil {
+		t.Errorf("Expected no proxy, got %+v (%v)", config, err)
+	}
+
+	t.Setenv("http_proxy", "proxy.corp:3128")
+	t.Setenv("NO_PROXY", "localhost, .internal.example,10.0.0.5:8080")
+	config, err := ProxyFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Default.String() != "http://proxy.corp:3128" {
+		t.Errorf("Unexpected default proxy %v", config.Default)
+	}
+	for _, host := range []string{"localhost", "internal.example", "a.internal.example", "10.0.0.5"} {
+		if config.ProxyFor(host) != nil {
+			t.Errorf("Expected %s to bypass the proxy", host)
+		}
+	}
+	if config.ProxyFor("example.com") == nil {
+		t.Error("Expected example.com to use the proxy")
+	}
+
+	t.Setenv("NO_PROXY", "*")
+	config, _ = ProxyFromEnvironment()
+	if config.ProxyFor("example.com") != nil {
+		t.Error("NO_PROXY=* should bypass the proxy for everything")
+	}
+
+	t.Setenv("HTTPS_PROXY", "ftp://proxy")
+	if _, err := ProxyFromEnvironment(); err == nil {
+		t.Error("Expected error for unsupported scheme")
+	}
+}
+
+// fakeProxy records the targets it is asked to reach and relays to them
+type fakeProxy struct {
+	mu      sync.Mutex
+	targets []string
+	auth    []string
+}
+
+func (p *fakeProxy) record(target, auth string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.targets = append(p.targets, target)
+	p.auth = append(p.auth, auth)
+}
+
+func (p *fakeProxy) seen() ([]string, []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...), append([]string(nil), p.auth...)
+}
+
+func (p *fakeProxy) serve(t *testing.T, handle func(conn net.Conn) (target string, ok bool)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target, ok := handle(conn)
+				if !ok {
+					return
+				}
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (p *fakeProxy) serveConnect(t *testing.T, status int) string {
+	return p.serve(t, func(conn net.Conn) (string, bool) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect {
+			return "", false
+		}
+		p.record(req.Host, req.Header.Get("Proxy-Authorization"))
+		io.WriteString(conn, "HTTP/1.1 "+strconv.Itoa(status)+" "+http.StatusText(status)+"\r\n\r\n")
+		return req.Host, status == http.StatusOK
+	})
+}
+
+// serveSOCKS5 speaks just enough SOCKS5 (RFC 1928/1929) for a username/password CONNECT
+func (p *fakeProxy) serveSOCKS5(t *testing.T) string {
+	return p.serve(t, func(conn net.Conn) (string, bool) {
+		r := bufio.NewReader(conn)
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return "", false
+		}
+		io.ReadFull(r, make([]byte, head[1]))
+		conn.Write([]byte{5, 2}) // Username/password
+
+		ver, _ := r.ReadByte()
+		ulen, _ := r.ReadByte()
+		user := make([]byte, ulen)
+		io.ReadFull(r, user)
+		plen, _ := r.ReadByte()
+		pass := make([]byte, plen)
+		io.ReadFull(r, pass)
+		if ver != 1 {
+			return "", false
+		}
+		conn.Write([]byte{1, 0})
+
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(r, req); err != nil {
+			return "", false
+		}
+		var host string
+		switch req[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 4:
+			ip := make([]byte, 16)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		default:
+			// A hostname would mean the proxy resolves it; record it so the test fails
+			n, _ := r.ReadByte()
+			name := make([]byte, n)
+			io.ReadFull(r, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(r, port)
+		target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+		p.record(target, string(user)+":"+string(pass))
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		return target, true
+	})
+}
+
+func newBackend(t *testing.T) (port string) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(ts.Close)
+	u, _ := url.Parse(ts.URL)
+	return u.Port()
+}
+
+func fetch(t *testing.T, policy *Policy, target string) (string, error) {
+	t.Helper()
+	client := &http.Client{Transport: NewSafeTransport(policy)}
+	resp, err := client.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestSafeTransport_HTTPConnectProxy(t *testing.T) {
+	port := newBackend(t)
+	p := &fakeProxy{}
+	proxyURL, _ := url.Parse("http://user:secret@" + p.serveConnect(t, http.StatusOK))
+
+	policy := &Policy{AllowPrivate: true, Proxy: &ProxyConfig{Default: proxyURL}}
+	body, err := fetch(t, policy, "http://localhost:"+port+"/")
+	if err != nil || body != "ok" {
+		t.Fatalf("Expected the request to go through the proxy, got %q (%v)", body, err)
+	}
+
+	targets, auth := p.seen()
+	if len(targets) == 0 {
+		t.Fatal("The proxy was not used")
+	}
+	// The proxy gets the validated IP, never the hostname
+	for _, target := range targets {
+		host, _, _ := net.SplitHostPort(target)
+		if net.ParseIP(host) == nil || !net.ParseIP(host).IsLoopback() {
+			t.Errorf("Expected CONNECT to a loopback IP, got %s", target)
+		}
+	}
+	if auth[0] != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("Unexpected Proxy-Authorization %q", auth[0])
+	}
+}
+
+func TestSafeTransport_ProxyRefused(t *testing.T) {
+	port := newBackend(t)
+	p := &fakeProxy{}
+	proxyURL, _ := url.Parse("http://" + p.serveConnect(t, http.StatusProxyAuthRequired))
+
+	policy := &Policy{AllowPrivate: true, Proxy: &ProxyConfig{Default: proxyURL}}
+	if _, err := fetch(t, policy, "http://127.0.0.1:"+port+"/"); err == nil {
+		t.Error("Expected an error when the proxy refuses the tunnel")
+	}
+}
+
+func TestSafeTransport_SOCKS5Proxy(t *testing.T) {
+	port := newBackend(t)
+	p := &fakeProxy{}
+	proxyURL, _ := url.Parse("socks5h://user:secret@" + p.serveSOCKS5(t))
+
+	policy := &Policy{AllowPrivate: true, Proxy: &ProxyConfig{Default: proxyURL}}
+	body, err := fetch(t, policy, "http://localhost:"+port+"/")
+	if err != nil || body != "ok" {
+		t.Fatalf("Expected the request to go through the proxy, got %q (%v)", body, err)
+	}
+
+	targets, auth := p.seen()
+	if len(targets) == 0 {
+		t.Fatal("The proxy was not used")
+	}
+	if host, _, _ := net.SplitHostPort(targets[0]); net.ParseIP(host) == nil {
+		t.Errorf("Expected the proxy to get an IP, got %s", targets[0])
+	}
+	if auth[0] != "user:secret" {
+		t.Errorf("Unexpected credentials %q", auth[0])
+	}
+}
+
+func TestSafeTransport_ProxyKeepsSSRFChecks(t *testing.T) {
+	port := newBackend(t)
+	p := &fakeProxy{}
+	proxyURL, _ := url.Parse("http://" + p.serveConnect(t, http.StatusOK))
+
+	// The proxy itself lives on loopback, but targets are still checked
+	policy := &Policy{Proxy: &ProxyConfig{Default: proxyURL}}
+	_, err := fetch(t, policy, "http://127.0.0.1:"+port+"/")
+	if !errors.Is(err, ErrBlockedIP) {
+		t.Errorf("Expected ErrBlockedIP through the proxy, got %v", err)
+	}
+	if targets, _ := p.seen(); len(targets) != 0 {
+		t.Errorf("The proxy should never be asked for a blocked target, got %v", targets)
+	}
+}
+
+func TestSafeTransport_ProxyRuleDirect(t *testing.T) {
+	port := newBackend(t)
+	p := &fakeProxy{}
+	proxyURL, _ := url.Parse("http://" + p.serveConnect(t, http.StatusOK))
+
+	policy := &Policy{AllowPrivate: true, Proxy: &ProxyConfig{
+		Default: proxyURL,
+		Rules:   []ProxyRule{{Pattern: "localhost"}},
+	}}
+	if body, err := fetch(t, policy, "http://localhost:"+port+"/"); err != nil || body != "ok" {
+		t.Fatalf("Expected a direct connection, got %q (%v)", body, err)
+	}
+	if targets, _ := p.seen(); len(targets) != 0 {
+		t.Errorf("Expected the proxy to be bypassed, got %v", targets)
+	}
+}
@@ -0,0 +1,238 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// minRetryBudget is the least time a retry must have left after its backoff to be worth sending
+const minRetryBudget = 50 * time.Millisecond
+
+// Retry resends idempotent requests that failed for transient reasons:
+// connection resets and refusals, 502, 503 and 504, and rate limits that say
+// when to come back (429, and GitHub's secondary limits, which answer 403 with
+// Retry-After). Waits grow exponentially with full jitter and never fall short
+// of what the server asked for. A retry that would wait longer than MaxDelay,
+// or couldn't finish before the request's deadline, isn't sent and the last
+// answer is returned instead. A nil *Retry sends every request once.
+type Retry struct {
+	// MaxAttempts counts the first attempt; 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, doubling for each one after
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff; a server asking for a longer wait isn't retried
+	MaxDelay time.Duration
+
+	jitter func(time.Duration) time.Duration
+}
+
+// DefaultRetry makes up to three attempts, which fits the resolvers' short deadlines
+func DefaultRetry() *Retry {
+	return &Retry{
+		MaxAttempts: 3,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    time.Second,
+	}
+}
+
+// Wrap returns a RoundTripper that retries through base
+func (r *Retry) Wrap(base http.RoundTripper) http.RoundTripper {
+	if r == nil || r.MaxAttempts <= 1 {
+		return base
+	}
+	return &retryTransport{retry: r, base: base}
+}
+
+// backoff is the wait before retry number n (from 1): a random duration up
+// to BaseDelay*2^(n-1), capped at MaxDelay
+func (r *Retry) backoff(n int) time.Duration {
+	ceiling := r.MaxDelay
+	if shift := n - 1; shift < 32 {
+		ceiling = min(r.BaseDelay<<shift, r.MaxDelay)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	if r.jitter != nil {
+		return r.jitter(ceiling)
+	}
+	return rand.N(ceiling + 1)
+}
+
+type retryTransport struct {
+	retry *Retry
+	base  http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !idempotent(req) {
+		return t.base.RoundTrip(req)
+	}
+	ctx := req.Context()
+
+	// last is the most recent retryable response, held in memory so it can
+	// still be returned if a later attempt fails outright
+	var last *http.Response
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt < t.retry.MaxAttempts {
+			attemptReq = req.WithContext(context.WithValue(ctx, retryPendingKey{}, true))
+		}
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil && last != nil && (attempt >= t.retry.MaxAttempts || !retryableError(ctx, err)) {
+			// Typically politeness refusing to go back before the origin's Retry-After
+			return last, nil
+		}
+		if attempt >= t.retry.MaxAttempts {
+			return resp, err
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if !retryableError(ctx, err) {
+				return nil, err
+			}
+		case retryableStatus(resp):
+			wait = serverDelay(resp.Header, time.Now())
+		default:
+			return resp, nil
+		}
+		if resp == nil {
+			resp = last
+		}
+		if wait > t.retry.MaxDelay {
+			return resp, err
+		}
+		wait = max(wait, t.retry.backoff(attempt))
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait+minRetryBudget {
+			if resp != nil {
+				return resp, nil
+			}
+			return nil, err
+		}
+		if resp != nil && resp != last {
+			if !holdBody(resp) {
+				return resp, nil // Too large to hold; the caller gets it now
+			}
+			last = resp
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+type retryPendingKey struct{}
+
+// retryPending reports whether the retry layer will resend req if it is
+// throttled, so the wait can be left to its backoff
+func retryPending(req *http.Request) bool {
+	pending, _ := req.Context().Value(retryPendingKey{}).(bool)
+	return pending
+}
+
+// maxHeldBody bounds the error body kept while retrying
+const maxHeldBody = 64 * 1024
+
+// holdBody reads a response's body into memory and closes the original, freeing
+// its connection and politeness slot. It reports false, leaving the body
+// readable as it was, if the body is larger than maxHeldBody.
+func holdBody(resp *http.Response) bool {
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, maxHeldBody+1))
+	if err != nil || len(buf) > maxHeldBody {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), resp.Body), resp.Body}
+		return false
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(buf))
+	return true
+}
+
+// idempotent reports whether req can safely be sent again
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryableError picks out failures another attempt might get past. Policy
+// refusals, broken limits, origin backoff and our own deadline are final.
+func retryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch {
+	case errors.Is(err, ErrBlockedIP), errors.Is(err, ErrBlockedHost), errors.Is(err, ErrBlockedPort),
+		errors.Is(err, ErrOriginBackoff), LimitCode(err) != "",
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryableStatus picks out responses that say to try again. A 403 only
+// counts when it carries rate-limit signals, as GitHub's secondary limits do.
+func retryableStatus(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
+}
+
+// serverDelay is how long the server asked us to wait: Retry-After in seconds
+// or as an HTTP date, or else the X-RateLimit-Reset time of an exhausted rate
+// limit; zero when it didn't say
+func serverDelay(h http.Header, now time.Time) time.Duration {
+	if ra := strings.TrimSpace(h.Get("Retry-After")); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return max(t.Sub(now), 0)
+		}
+	}
+	if h.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(now), 0)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// noJitter waits the full backoff, so tests can reason about timing
+func noJitter(d time.Duration) time.Duration { return d }
+
+func retryClient(r *Retry, base http.RoundTripper) *http.Client {
+	r.jitter = noJitter
+	return &http.Client{Transport: r.Wrap(base)}
+}
+
+func TestRetry_TransientStatuses(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []int
+		header   http.Header
+		calls    int32
+		want     int
+	}{
+		{"recovers from 503", []int{503, 502}, nil, 3, 200},
+		{"gives up after MaxAttempts", []int{504, 504, 504, 504}, nil, 3, 504},
+		{"404 is final", []int{404}, nil, 1, 404},
+		{"plain 403 is final", []int{403}, nil, 1, 403},
+		{"secondary rate limit", []int{403}, http.Header{"Retry-After": {"0"}}, 2, 200},
+		{"exhausted rate limit", []int{403}, http.Header{"X-Ratelimit-Remaining": {"0"}}, 2, 200},
+		{"Retry-After beyond MaxDelay", []int{429}, http.Header{"Retry-After": {"60"}}, 1, 429},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1))
+				if n <= len(tc.failures) {
+					for k, v := range tc.header {
+						w.Header()[k] = v
+					}
+					w.WriteHeader(tc.failures[n-1])
+					io.WriteString(w, "failure "+strconv.Itoa(n))
+					return
+				}
+				io.WriteString(w, "ok")
+			}))
+			defer ts.Close()
+
+			client := retryClient(&Retry{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}, http.DefaultTransport)
+			resp, err := client.Get(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tc.want || calls.Load() != tc.calls {
+				t.Errorf("Expected %d after %d calls, got %d after %d", tc.want, tc.calls, resp.StatusCode, calls.Load())
+			}
+			if resp.StatusCode != 200 && !strings.HasPrefix(string(body), "failure") {
+				t.Errorf("Expected the failure body to be readable, got %q", body)
+			}
+		})
+	}
+}
+
+type flakyTransport struct {
+	calls atomic.Int32
+	fail  int32
+	err   error
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if f.calls.Add(1) <= f.fail {
+		return nil, f.err
+	}
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("ok")), Request: req}, nil
+}
+
+func TestRetry_Errors(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	tests := []struct {
+		name  string
+		err   error
+		calls int32
+	}{
+		{"connection reset", reset, 2},
+		{"unexpected EOF", io.ErrUnexpectedEOF, 2},
+		{"blocked by policy", ErrBlockedIP, 1},
+		{"broken limit", ErrBodyTooLarge, 1},
+		{"origin backoff", ErrOriginBackoff, 1},
+		{"other errors", errors.New("x509: certificate signed by unknown authority"), 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			base := &flakyTransport{fail: 1, err: tc.err}
+			client := retryClient(&Retry{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, base)
+			client.Get("http://example.com/")
+			if base.calls.Load() != tc.calls {
+				t.Errorf("Expected %d calls, got %d", tc.calls, base.calls.Load())
+			}
+		})
+	}
+
+	// Requests that may not be idempotent are never resent
+	base := &flakyTransport{fail: 1, err: io.ErrUnexpectedEOF}
+	client := retryClient(DefaultRetry(), base)
+	client.Post("http://example.com/", "text/plain", strings.NewReader("body"))
+	if base.calls.Load() != 1 {
+		t.Errorf("Expected a POST to be sent once, got %d calls", base.calls.Load())
+	}
+}
+
+func TestRetry_BoundedByDeadline(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := retryClient(&Retry{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, http.DefaultTransport)
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the last 503 back before the deadline, got %v (%v)", resp, err)
+	}
+	resp.Body.Close()
+	// Waits of 100ms then 200ms: the second would overrun the deadline, so it isn't taken
+	if calls.Load() != 2 || time.Since(start) > 200*time.Millisecond {
+		t.Errorf("Expected 2 calls within the deadline, got %d in %v", calls.Load(), time.Since(start))
+	}
+}
+
+// backoffTransport answers 503 once, then refuses as politeness does while an origin is held
+type backoffTransport struct {
+	calls atomic.Int32
+}
+
+func (b *backoffTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b.calls.Add(1) == 1 {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader("busy")), Request: req}, nil
+	}
+	return nil, ErrOriginBackoff
+}
+
+func TestRetry_ReturnsResponseWhenPolitenessHoldsBack(t *testing.T) {
+	base := &backoffTransport{}
+	client := retryClient(DefaultRetry(), base)
+
+	resp, err := client.Get("http://example.com/")
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the 503 itself, got %v (%v)", resp, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "busy" || base.calls.Load() != 2 {
+		t.Errorf("Expected the held 503 body after 2 calls, got %q after %d", body, base.calls.Load())
+	}
+}
+
+func TestRetry_WithPoliteness(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	// A 429 without Retry-After is retried on our backoff rather than politeness' 5s hold
+	p := NewPoliteness(4, 0, 0)
+	client := retryClient(DefaultRetry(), p.Wrap(http.DefaultTransport))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected the last 429, got %v (%v)", resp, err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls.Load())
+	}
+
+	// Once the retries are spent, the origin is held for everyone
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, ErrOriginBackoff) {
+		t.Errorf("Expected ErrOriginBackoff after the final attempt, got %v", err)
+	}
+}
+
+func TestRetry_Backoff(t *testing.T) {
+	r := &Retry{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond, jitter: noJitter}
+	for n, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond} {
+		if got := r.backoff(n + 1); got != want {
+			t.Errorf("backoff(%d) = %v; want %v", n+1, got, want)
+		}
+	}
+	r.jitter = nil
+	for i := 0; i < 100; i++ {
+		if d := r.backoff(2); d < 0 || d > 200*time.Millisecond {
+			t.Fatalf("Jittered backoff out of range: %v", d)
+		}
+	}
+	if d := r.backoff(1000); d > r.MaxDelay {
+		t.Error("Expected the backoff to stay capped for large attempt numbers")
+	}
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := http.Header{}
+	h.Set("X-RateLimit-Remaining", "0")
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(30*time.Second).Unix(), 10))
+	if d := serverDelay(h, now); d != 30*time.Second {
+		t.Errorf("Expected the rate limit reset to be honoured, got %v", d)
+	}
+	h.Set("Retry-After", now.Add(5*time.Second).Format(http.TimeFormat))
+	if d := serverDelay(h, now); d != 5*time.Second {
+		t.Errorf("Expected Retry-After to take precedence, got %v", d)
+	}
+}
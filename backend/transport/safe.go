@@ -5,113 +5,105 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"time"
 )
 
-var (
-	// Private IP blocks (RFC 1918, RFC 4193, RFC 4291)
-	privateBlocks = []string{
-		"127.0.0.0/8",    // IPv4 loopback
-		"10.0.0.0/8",     // RFC1918
-		"172.16.0.0/12",  // RFC1918
-		"192.168.0.0/16", // RFC1918
-		"169.254.0.0/16", // RFC3927 link-local
-		"::1/128",        // IPv6 loopback
-		"fc00::/7",       // IPv6 unique local
-		"fe80::/10",      // IPv6 link-local
-	}
-
-	cidrs []*net.IPNet
-
-	// AllowLocalIPs should only be true during testing
-	AllowLocalIPs = false
-)
-
-func init() {
-	for _, b := range privateBlocks {
-		_, cidr, err := net.ParseCIDR(b)
+// SafeDialer returns a dial function that only connects to destinations the policy allows.
+// The port and hostname are checked before resolution, every resolved address after it,
+// and connections are only made to validated addresses so DNS rebinding can't swap them.
+func SafeDialer(dialer *net.Dialer, policy *Policy) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, portStr, err := net.SplitHostPort(addr)
 		if err != nil {
-			panic(err) // Should never happen with constant strings
-		}
-		cidrs = append(cidrs, cidr)
-	}
-}
-
-func isPrivateIP(ip net.IP) bool {
-	if AllowLocalIPs {
-		return false
-	}
-	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
-		return true
-	}
-	for _, block := range cidrs {
-		if block.Contains(ip) {
-			return true
+			return nil, err
 		}
-	}
-	return false
-}
-
-// SafeDialer returns a dial function that blocks private IPs
-func SafeDialer(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
-	return func(ctx context.Context, network, addr string) (net.Conn, error) {
-		host, port, err := net.SplitHostPort(addr)
+		port, err := strconv.Atoi(portStr)
 		if err != nil {
 			return nil, err
 		}
+		if err := policy.CheckPort(port); err != nil {
+			return nil, err
+		}
 
-		// Resolve IP
-		ips, err := dialer.Resolver.LookupIPAddr(ctx, host)
-		if err != nil {
+		if err := policy.CheckHost(host); err != nil {
 			return nil, err
 		}
+		var ips []netip.Addr
+		if literal, err := netip.ParseAddr(host); err == nil {
+			ips = []netip.Addr{literal}
+		} else {
+			resolved, err := policy.resolver().LookupNetIP(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			ips = resolved
+		}
 
 		if len(ips) == 0 {
 			return nil, errors.New("no IP addresses found")
 		}
 
-		// Check first IP (or iterate)
-		// For strict safety, we dial the first valid one we find, but we must validate it.
-		var targetIP net.IP
+		// Every address is checked, and all that pass are raced so one dead
+		// record doesn't fail a host that has others
+		var allowed []netip.Addr
 		for _, ip := range ips {
-			if isPrivateIP(ip.IP) {
-				continue // Skip private IPs
+			if matchesNetwork(network, ip) && policy.CheckIP(ip) == nil {
+				allowed = append(allowed, ip)
 			}
-			targetIP = ip.IP
-			break
 		}
 
-		if targetIP == nil {
-			return nil, errors.New("blocked: resolves to private/local IP")
+		if len(allowed) == 0 {
+			return nil, ErrBlockedIP
+		}
+
+		// The proxy, if any, is handed the validated IPs too, never the hostname
+		dial := dialFunc(dialer.DialContext)
+		if proxyURL := policy.Proxy.ProxyFor(host); proxyURL != nil {
+			viaProxy, err := proxyDialer(dialer, proxyURL)
+			if err != nil {
+				return nil, err
+			}
+			// Remember the destination for the audit log, since the remote address is the proxy's
+			dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := viaProxy(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				ip, _, _ := net.SplitHostPort(addr)
+				return &dialedConn{Conn: conn, ip: ip}, nil
+			}
 		}
 
-		// Dial the specific IP
-		// We reconstruct the address using the validated IP
-		// Note: This prevents DNS rebinding because we validated THIS IP.
-		// However, for TLS (HTTPS), we need the hostname for SNI.
-		// net.Dialer handles this if we pass the original hostname?
-		// No, dialer takes (network, address). If we pass IP:Port, SNI might break.
-		// But SafeDialer is used for the TCP connection. TLS handshake happens ON TOP of this connection.
-		// The http.Transport handles SNI using the Request.URL.Host.
-		// So dialing IP:Port is safe for the TCP layer.
-		
-		return dialer.DialContext(ctx, network, net.JoinHostPort(targetIP.String(), port))
+		// TLS runs on top of this connection and takes SNI from the request URL,
+		// so dialing the IP directly keeps certificates working
+		return dialParallel(ctx, dial, dialer.FallbackDelay, network, interleave(allowed), portStr)
 	}
 }
 
-// NewSafeTransport returns an http.Transport configured for security
-func NewSafeTransport() *http.Transport {
+// NewSafeTransport returns a RoundTripper that only reaches destinations the
+// policy allows, paces them by its Politeness, holds responses to its Limits
+// and reports every request, including those politeness holds back, to its
+// Audit sink
+func NewSafeTransport(policy *Policy) http.RoundTripper {
 	dialer := &net.Dialer{
 		Timeout:   2 * time.Second, // Fast connect timeout
 		KeepAlive: 30 * time.Second,
 	}
 
-	return &http.Transport{
-		DialContext:           SafeDialer(dialer),
+	t := &http.Transport{
+		DialContext:           SafeDialer(dialer, policy),
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   5 * time.Second, // Fast TLS timeout
 		ExpectContinueTimeout: 1 * time.Second,
 	}
+	if policy.Limits != nil {
+		// The limits decompress bodies themselves so they can measure the ratio
+		t.DisableCompression = true
+		t.MaxResponseHeaderBytes = policy.Limits.MaxHeaderBytes
+	}
+	return auditWrap(policy.Audit, policy.Politeness.Wrap(policy.Limits.wrap(t)))
 }
@@ -2,40 +2,218 @@ package transport
 
 import (
 	"context"
+	"errors"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"testing"
 )
 
-func TestIsPrivateIP(t *testing.T) {
+func TestPolicy_CheckIP_Default(t *testing.T) {
 	tests := []struct {
 		ip      string
-		private bool
+		blocked bool
 	}{
+		// Private and loopback
 		{"127.0.0.1", true},
+		{"127.255.255.254", true},
 		{"10.0.0.1", true},
 		{"192.168.1.1", true},
 		{"172.16.0.1", true},
-		{"172.31.255.255", true}, // Private range
-		{"169.254.169.254", true}, // Link-local
-		{"8.8.8.8", false},       // Google DNS
-		{"1.1.1.1", false},       // Cloudflare
-		{"::1", true},            // IPv6 Loopback
-		{"fc00::1", true},        // IPv6 Unique Local
-		{"2001:4860:4860::8888", false}, // IPv6 Public
+		{"172.31.255.255", true},
+		{"172.32.0.1", false},
+		{"169.254.169.254", true}, // Cloud metadata
+		// Ranges the old blocklist missed
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"100.64.0.1", true}, // CGNAT
+		{"100.127.255.255", true},
+		{"100.128.0.1", false},
+		{"192.0.0.8", true},
+		{"192.0.2.1", true},    // TEST-NET-1
+		{"198.51.100.7", true}, // TEST-NET-2
+		{"203.0.113.9", true},  // TEST-NET-3
+		{"198.18.0.1", true},   // Benchmarking
+		{"198.19.255.255", true},
+		{"198.20.0.1", false},
+		{"192.88.99.1", true},
+		{"224.0.0.1", true}, // Multicast
+		{"239.255.255.250", true},
+		{"240.0.0.1", true},
+		{"255.255.255.255", true},
+		// Public IPv4
+		{"8.8.8.8", false},
+		{"1.1.1.1", false},
+		{"93.184.216.34", false},
+		// IPv6
+		{"::", true},
+		{"::1", true},
+		{"fc00::1", true},
+		{"fd12:3456::1", true},
+		{"fe80::1", true},
+		{"fe80::1%eth0", true},
+		{"fec0::1", true},
+		{"ff02::1", true},
+		{"2001:db8::1", true},
+		{"2001::1", true}, // Teredo
+		{"100::1", true},
+		{"64:ff9b:1::1", true},
+		{"2001:4860:4860::8888", false},
+		{"2606:4700:4700::1111", false},
+		// IPv4 embedded in IPv6 is judged by the IPv4 address
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.1.2.3", true},
+		{"::ffff:169.254.169.254", true},
+		{"::ffff:8.8.8.8", false},
+		{"64:ff9b::7f00:1", true}, // NAT64 of 127.0.0.1
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b::808:808", false}, // NAT64 of 8.8.8.8
+		{"2002:7f00:1::1", true},    // 6to4 of 127.0.0.1
+		{"2002:c0a8:101::1", true},  // 6to4 of 192.168.1.1
+		{"2002:808:808::1", false},
+		{"::7f00:1", true}, // IPv4-compatible 127.0.0.1
+		{"::a00:1", true},
 	}
 
+	p := DefaultPolicy()
 	for _, tc := range tests {
-		ip := net.ParseIP(tc.ip)
-		if got := isPrivateIP(ip); got != tc.private {
-			t.Errorf("isPrivateIP(%s) = %v; want %v", tc.ip, got, tc.private)
+		ip, err := netip.ParseAddr(tc.ip)
+		if err != nil {
+			t.Fatalf("bad test address %s: %v", tc.ip, err)
+		}
+		err = p.CheckIP(ip)
+		if got := err != nil; got != tc.blocked {
+			t.Errorf("CheckIP(%s) blocked = %v; want %v (%v)", tc.ip, got, tc.blocked, err)
+		}
+		if err != nil && !errors.Is(err, ErrBlockedIP) {
+			t.Errorf("CheckIP(%s) = %v; want ErrBlockedIP", tc.ip, err)
+		}
+	}
+}
+
+func TestPolicy_CheckIP_Lists(t *testing.T) {
+	allow, _ := ParsePrefixes("10.20.0.0/16, 192.168.1.5")
+	deny, _ := ParsePrefixes("8.8.8.0/24,2606:4700::/32")
+	p := &Policy{AllowCIDRs: allow, DenyCIDRs: deny}
+
+	tests := []struct {
+		policy  *Policy
+		ip      string
+		blocked bool
+	}{
+		{p, "10.20.1.1", false},   // Allowed internal range
+		{p, "10.21.1.1", true},    // Still private
+		{p, "192.168.1.5", false}, // Single allowed host
+		{p, "192.168.1.6", true},
+		{p, "::ffff:10.20.1.1", false}, // Mapped form of an allowed address
+		{p, "8.8.8.8", true},           // Denied public range
+		{p, "8.8.4.4", false},
+		{p, "2606:4700:4700::1111", true},
+		{p, "64:ff9b::808:808", true},                                 // NAT64 of a denied address
+		{&Policy{AllowCIDRs: deny, DenyCIDRs: deny}, "8.8.8.8", true}, // Deny wins over allow
+		{LocalPolicy(), "127.0.0.1", false},
+		{LocalPolicy(), "::1", false},
+		{&Policy{AllowPrivate: true, DenyCIDRs: deny}, "8.8.8.8", true},
+	}
+	for _, tc := range tests {
+		err := tc.policy.CheckIP(netip.MustParseAddr(tc.ip))
+		if got := err != nil; got != tc.blocked {
+			t.Errorf("%+v CheckIP(%s) blocked = %v; want %v", tc.policy, tc.ip, got, tc.blocked)
+		}
+	}
+}
+
+func TestPolicy_CheckHost(t *testing.T) {
+	tests := []struct {
+		policy  *Policy
+		host    string
+		blocked bool
+	}{
+		{DefaultPolicy(), "example.com", false},
+		{&Policy{DenyHosts: []string{"metadata.google.internal"}}, "metadata.google.internal", true},
+		{&Policy{DenyHosts: []string{"metadata.google.internal"}}, "METADATA.google.internal.", true},
+		{&Policy{DenyHosts: []string{"*.internal"}}, "api.corp.internal", true},
+		{&Policy{DenyHosts: []string{"*.internal"}}, "internal", false},
+		{&Policy{DenyHosts: []string{"*.example.com"}}, "notexample.com", false},
+		{&Policy{AllowHosts: []string{"*.example.com", "example.org"}}, "www.example.com", false},
+		{&Policy{AllowHosts: []string{"*.example.com", "example.org"}}, "example.org", false},
+		{&Policy{AllowHosts: []string{"*.example.com", "example.org"}}, "example.net", true},
+		{&Policy{AllowHosts: []string{"*.example.com"}, DenyHosts: []string{"admin.example.com"}}, "admin.example.com", true},
+		// IP literals skip DNS, so an allow list only admits the ones it names
+		{&Policy{AllowHosts: []string{"*.example.com"}}, "93.184.216.34", true},
+		{&Policy{AllowHosts: []string{"*.34.216.184"}}, "93.184.216.34", true},
+		{&Policy{AllowHosts: []string{"*.example.com", "93.184.216.34"}}, "93.184.216.34", false},
+		{&Policy{AllowHosts: []string{"*.example.com"}, AllowCIDRs: []netip.Prefix{netip.MustParsePrefix("93.184.216.0/24")}}, "93.184.216.34", false},
+		{&Policy{AllowHosts: []string{"*.example.com"}}, "2606:2800:220:1::1", true},
+		{DefaultPolicy(), "93.184.216.34", false},
+	}
+	for _, tc := range tests {
+		err := tc.policy.CheckHost(tc.host)
+		if got := err != nil; got != tc.blocked {
+			t.Errorf("%+v CheckHost(%s) blocked = %v; want %v", tc.policy, tc.host, got, tc.blocked)
+		}
+		if err != nil && !errors.Is(err, ErrBlockedHost) {
+			t.Errorf("CheckHost(%s) = %v; want ErrBlockedHost", tc.host, err)
+		}
+	}
+}
+
+func TestPolicy_CheckPort(t *testing.T) {
+	web := &Policy{Ports: []int{80, 443}}
+	tests := []struct {
+		policy  *Policy
+		port    int
+		blocked bool
+	}{
+		{DefaultPolicy(), 22, false},
+		{web, 80, false},
+		{web, 443, false},
+		{web, 22, true},
+		{web, 8080, true},
+	}
+	for _, tc := range tests {
+		err := tc.policy.CheckPort(tc.port)
+		if got := err != nil; got != tc.blocked {
+			t.Errorf("CheckPort(%d) blocked = %v; want %v", tc.port, got, tc.blocked)
+		}
+		if err != nil && !errors.Is(err, ErrBlockedPort) {
+			t.Errorf("CheckPort(%d) = %v; want ErrBlockedPort", tc.port, err)
 		}
 	}
 }
 
+func TestParsers(t *testing.T) {
+	prefixes, err := ParsePrefixes(" 10.0.0.0/8 ,::1, 192.168.1.7/24,")
+	if err != nil || len(prefixes) != 3 || prefixes[1].Bits() != 128 || prefixes[2].String() != "192.168.1.0/24" {
+		t.Errorf("Unexpected prefixes %v (%v)", prefixes, err)
+	}
+	if _, err := ParsePrefixes("10.0.0.0/33"); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+	if _, err := ParsePrefixes("example.com"); err == nil {
+		t.Error("Expected error for hostname")
+	}
+
+	ports, err := ParsePorts("80, 443")
+	if err != nil || len(ports) != 2 || ports[1] != 443 {
+		t.Errorf("Unexpected ports %v (%v)", ports, err)
+	}
+	for _, bad := range []string{"0", "65536", "http"} {
+		if _, err := ParsePorts(bad); err == nil {
+			t.Errorf("Expected error for port %q", bad)
+		}
+	}
+
+	if hosts := ParseHosts(" a.com,,*.b.com "); len(hosts) != 2 || hosts[1] != "*.b.com" {
+		t.Errorf("Unexpected hosts %v", hosts)
+	}
+}
+
 func TestSafeDialer_Blocked(t *testing.T) {
 	// Create a safe dialer
 	dialer := &net.Dialer{}
-	safeDial := SafeDialer(dialer)
+	safeDial := SafeDialer(dialer, DefaultPolicy())
 
 	// Try to dial localhost (should fail)
 	// We use a random port that is likely closed, but the blocking happens BEFORE connection
@@ -51,4 +229,61 @@ func TestSafeDialer_Blocked(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error for 192.168.1.1, got nil")
 	}
+
+	// IPv4-mapped loopback
+	if _, err = safeDial(context.Background(), "tcp", "[::ffff:127.0.0.1]:80"); !errors.Is(err, ErrBlockedIP) {
+		t.Errorf("Expected ErrBlockedIP for mapped loopback, got %v", err)
+	}
+
+	// Port restrictions apply before anything is resolved
+	webOnly := SafeDialer(dialer, &Policy{Ports: []int{80, 443}})
+	if _, err = webOnly(context.Background(), "tcp", "example.com:22"); !errors.Is(err, ErrBlockedPort) {
+		t.Errorf("Expected ErrBlockedPort, got %v", err)
+	}
+
+	// An allow list holds for IP literals as well
+	allowList := SafeDialer(dialer, &Policy{AllowHosts: []string{"*.example.com"}})
+	if _, err = allowList(context.Background(), "tcp", "93.184.216.34:80"); !errors.Is(err, ErrBlockedHost) {
+		t.Errorf("Expected ErrBlockedHost for an unlisted IP literal, got %v", err)
+	}
+
+	// So do host patterns
+	noInternal := SafeDialer(dialer, &Policy{DenyHosts: []string{"*.internal"}})
+	if _, err = noInternal(context.Background(), "tcp", "metadata.google.internal:80"); !errors.Is(err, ErrBlockedHost) {
+		t.Errorf("Expected ErrBlockedHost, got %v", err)
+	}
+}
+
+func TestNewSafeTransport_PerClientPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	// Two clients side by side: the policy belongs to the client, not the package
+	strict := &http.Client{Transport: NewSafeTransport(DefaultPolicy())}
+	local := &http.Client{Transport: NewSafeTransport(LocalPolicy())}
+
+	if _, err := strict.Get(ts.URL); !errors.Is(err, ErrBlockedIP) {
+		t.Errorf("Expected the strict client to be blocked, got %v", err)
+	}
+	resp, err := local.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Expected the local client to connect, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestPolicy_WithoutPoliteness(t *testing.T) {
+	p := DefaultPolicy()
+	p.Politeness = NewPoliteness(1, 0, 0)
+	p.DenyHosts = []string{"internal.example"}
+
+	api := p.WithoutPoliteness()
+	if api.Politeness != nil || p.Politeness == nil {
+		t.Error("Expected only the copy to drop politeness")
+	}
+	if len(api.DenyHosts) != 1 || api.Limits != p.Limits {
+		t.Error("Expected the rest of the policy to carry over")
+	}
 }